@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnauthenticated is returned when a request carries no valid credentials
+var ErrUnauthenticated = errors.New("authentication required")
+
+type contextKey struct{}
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// UserFromContext returns the authenticated user, or nil
+func UserFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(contextKey{}).(*User)
+	return u
+}
+
+// sessionClaims is the payload of a session token
+type sessionClaims struct {
+	UserID    string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Authenticator issues session tokens and resolves request credentials
+type Authenticator struct {
+	store  *Store
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewAuthenticator(store *Store, secret []byte, ttl time.Duration) *Authenticator {
+	return &Authenticator{store: store, secret: secret, ttl: ttl}
+}
+
+// Store returns the user and token store
+func (a *Authenticator) Store() *Store {
+	return a.store
+}
+
+// Login checks a username and password and issues a session token
+func (a *Authenticator) Login(username, password string) (string, time.Time, error) {
+	u, err := a.store.CheckPassword(username, password)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(a.ttl)
+	token, err := a.sign(sessionClaims{UserID: u.ID, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Authenticate resolves the user behind a request. Credentials are read
+// from the Authorization bearer header, or from the token query parameter
+// for clients such as browsers opening a WebSocket that cannot set headers.
+func (a *Authenticator) Authenticate(r *http.Request) (*User, error) {
+	token, _ := credential(r)
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	if strings.HasPrefix(token, APITokenPrefix) {
+		u, err := a.store.LookupAPIToken(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil {
+			return nil, ErrUnauthenticated
+		}
+		return u, nil
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	u, err := a.store.GetUser(r.Context(), claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUnauthenticated
+	}
+	return u, nil
+}
+
+// credential returns the token of a request and whether it was read from
+// the Authorization header rather than the token query parameter
+func credential(r *http.Request) (string, bool) {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer "), true
+	}
+	return r.URL.Query().Get("token"), false
+}
+
+// StripCredential removes the credential Authenticate reads from r, so that
+// it is not passed on: the Authorization header if it holds a bearer token,
+// and otherwise the token query parameter. Other headers and parameters are
+// left alone, since they may be meant for a function.
+func StripCredential(r *http.Request) {
+	if _, header := credential(r); header {
+		r.Header.Del("Authorization")
+		return
+	}
+	q := r.URL.Query()
+	if q.Has("token") {
+		q.Del("token")
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
+// sign encodes the claims as base64(payload).base64(HMAC-SHA256(payload))
+func (a *Authenticator) sign(claims sessionClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("error encoding session: %v", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(a.mac(encoded)), nil
+}
+
+func (a *Authenticator) verify(token string) (*sessionClaims, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.New("malformed session token")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, a.mac(encoded)) {
+		return nil, errors.New("invalid session signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("malformed session token")
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed session token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("session expired")
+	}
+	return &claims, nil
+}
+
+func (a *Authenticator) mac(data string) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
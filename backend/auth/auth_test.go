@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripCredential(t *testing.T) {
+	for _, tt := range []struct {
+		name, authorization, target  string
+		wantAuthorization, wantQuery string
+	}{
+		{"bearer header", "Bearer sls_abc", "/invoke/hello/?token=fn", "", "token=fn"},
+		{"token parameter", "", "/ws?token=sls_abc&x=1", "", "x=1"},
+		// Only a bearer header is read, so another scheme is the
+		// function's and the parameter is the backend's
+		{"other scheme", "Basic dXNlcjpwYXNz", "/invoke/hello/?token=sls_abc", "Basic dXNlcjpwYXNz", ""},
+		{"no credential", "", "/invoke/hello/?x=1", "", "x=1"},
+	} {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		r.Header.Set("Cookie", "session=fn")
+		StripCredential(r)
+		if got := r.Header.Get("Authorization"); got != tt.wantAuthorization {
+			t.Errorf("%s: got Authorization %q, want %q", tt.name, got, tt.wantAuthorization)
+		}
+		if got := r.URL.RawQuery; got != tt.wantQuery {
+			t.Errorf("%s: got query %q, want %q", tt.name, got, tt.wantQuery)
+		}
+		if got := r.Header.Get("Cookie"); got != "session=fn" {
+			t.Errorf("%s: got Cookie %q, want it forwarded", tt.name, got)
+		}
+	}
+}
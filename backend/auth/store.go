@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// APITokenPrefix marks long-lived API tokens so they can be told apart from
+// session tokens without a database lookup
+const APITokenPrefix = "sls_"
+
+// ErrInvalidCredentials is returned for an unknown user or wrong password
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// User is a backend user
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// APIToken describes a long-lived token. The token itself is only returned
+// once, when it is created; the database stores its SHA-256 hash.
+type APIToken struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+}
+
+// Store keeps users and API tokens in SQLite
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore returns a store backed by the users and api_tokens tables
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// CountUsers returns the number of users
+func (s *Store) CountUsers() (int, error) {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		return 0, fmt.Errorf("error counting users: %v", err)
+	}
+	return n, nil
+}
+
+// CreateUser stores a new user with a bcrypt hash of the password
+func (s *Store) CreateUser(username, password string) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %v", err)
+	}
+	u := &User{
+		ID:        uuid.New().String(),
+		Username:  username,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO users (id, username, password_hash, created_at)
+		VALUES (?, ?, ?, ?)
+	`, u.ID, u.Username, string(hash), u.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating user: %v", err)
+	}
+	return u, nil
+}
+
+// GetUser retrieves a user by ID
+func (s *Store) GetUser(ctx context.Context, id string) (*User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, username, created_at FROM users WHERE id = ?
+	`, id).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting user: %v", err)
+	}
+	return &u, nil
+}
+
+// CheckPassword returns the user if the password matches
+func (s *Store) CheckPassword(username, password string) (*User, error) {
+	var u User
+	var hash string
+	err := s.db.QueryRow(`
+		SELECT id, username, created_at, password_hash FROM users WHERE username = ?
+	`, username).Scan(&u.ID, &u.Username, &u.CreatedAt, &hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("error getting user: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &u, nil
+}
+
+// CreateAPIToken issues a new API token for the user and returns it in
+// plain text together with its metadata
+func (s *Store) CreateAPIToken(userID, name string) (string, *APIToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("error generating token: %v", err)
+	}
+	token := APITokenPrefix + hex.EncodeToString(secret)
+
+	t := &APIToken{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO api_tokens (id, user_id, name, token_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, t.ID, userID, t.Name, hashToken(token), t.CreatedAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating api token: %v", err)
+	}
+	return token, t, nil
+}
+
+// LookupAPIToken returns the owner of an API token, or nil if the token is
+// unknown
+func (s *Store) LookupAPIToken(ctx context.Context, token string) (*User, error) {
+	var tokenID string
+	var u User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT t.id, u.id, u.username, u.created_at
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?
+	`, hashToken(token)).Scan(&tokenID, &u.ID, &u.Username, &u.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error looking up api token: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?",
+		time.Now().Format(time.RFC3339), tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating api token: %v", err)
+	}
+	return &u, nil
+}
+
+// ListAPITokens returns the API tokens of a user
+func (s *Store) ListAPITokens(userID string) ([]APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, created_at, COALESCE(last_used_at, '')
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying api tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("error scanning api token: %v", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes one of the user's API tokens. It reports whether
+// a token was deleted.
+func (s *Store) DeleteAPIToken(userID, id string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, fmt.Errorf("error deleting api token: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error deleting api token: %v", err)
+	}
+	return n > 0, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Bootstrap creates the initial user when the store has none. If password
+// is empty a random one is generated and returned so it can be shown once.
+func (s *Store) Bootstrap(username, password string) (string, error) {
+	n, err := s.CountUsers()
+	if err != nil || n > 0 {
+		return "", err
+	}
+	if password == "" {
+		secret := make([]byte, 12)
+		if _, err := rand.Read(secret); err != nil {
+			return "", fmt.Errorf("error generating password: %v", err)
+		}
+		password = hex.EncodeToString(secret)
+	}
+	if _, err := s.CreateUser(username, password); err != nil {
+		return "", err
+	}
+	return password, nil
+}
@@ -8,6 +8,54 @@ import (
 type Config struct {
 	Server struct {
 		Port string
+		// AllowedOrigins lists the origins allowed to make cross-origin
+		// requests; "*" allows any origin
+		AllowedOrigins []string
+	}
+	Shutdown struct {
+		// Timeout bounds draining requests and waiting for builds and
+		// invocations in progress when the backend is asked to stop
+		Timeout time.Duration
+		// Functions is what happens to running functions on shutdown:
+		// "stop" stops them and "detach" leaves them running to be adopted
+		// by the next backend
+		Functions string
+	}
+	Auth struct {
+		// SessionSecret signs session tokens. When empty a random secret is
+		// generated at startup and sessions do not survive a restart.
+		SessionSecret string `config:"secret"`
+		SessionTTL    time.Duration
+		// AdminUsername and AdminPassword create the first user when the
+		// database has none. An empty password is generated and logged.
+		AdminUsername string
+		AdminPassword string `config:"secret"`
+	}
+	Logging struct {
+		// Level is debug, info, warn or error
+		Level string
+		// Format is json or text
+		Format string
+	}
+	Tracing struct {
+		// Exporter is where spans are sent: "none", "stdout" or "otlp"
+		Exporter string
+		// Endpoint is the base URL of the OTLP/HTTP collector for the otlp
+		// exporter
+		Endpoint string
+		// SampleRatio is the fraction of new traces that are recorded
+		SampleRatio float64
+	}
+	Logs struct {
+		// MaxAttempts is the number of build and run logs kept per deployment
+		MaxAttempts int
+		// MaxFileSize rotates a log once it grows beyond this many bytes
+		MaxFileSize int64
+	}
+	Secrets struct {
+		// Key encrypts secret environment variables at rest. Secrets cannot
+		// be stored while it is empty.
+		Key string `config:"secret"`
 	}
 	Registry struct {
 		Address string
@@ -15,6 +63,51 @@ type Config struct {
 	Function struct {
 		PortDetectionTimeout time.Duration
 		DataDir              string
+		// Runtime selects how functions are created, built and run:
+		// "knative" (func CLI) or "native" (local processes)
+		Runtime string
+		// IdleTimeout stops running functions that have not been invoked
+		// for this long. Zero disables scale-to-zero.
+		IdleTimeout time.Duration
+	}
+	Ports struct {
+		// Min and Max bound the ports assigned to functions, for runtimes
+		// that let the backend choose them
+		Min int
+		Max int
+	}
+	Schedules struct {
+		// Timeout bounds each scheduled invocation, including a cold start
+		Timeout time.Duration
+	}
+	Queue struct {
+		// Workers is the number of asynchronous invocations delivered at
+		// the same time
+		Workers int
+		// MaxAttempts is how often an invocation is tried before it becomes
+		// a dead letter
+		MaxAttempts int
+		// RetryBackoff is the delay after the first failed attempt. It
+		// doubles with each further attempt up to MaxRetryBackoff.
+		RetryBackoff    time.Duration
+		MaxRetryBackoff time.Duration
+		// Timeout bounds each attempt, including a cold start
+		Timeout time.Duration
+	}
+	Health struct {
+		// Defaults for deployments that don't configure their own health
+		// check and restart policy
+		CheckType        string
+		CheckPath        string
+		CheckInterval    time.Duration
+		CheckTimeout     time.Duration
+		FailureThreshold int
+		RestartPolicy    string
+		// RestartBackoff is the delay before restarting a crashed function.
+		// It doubles with each consecutive restart up to MaxRestartBackoff,
+		// and starts over once a function stays up for MaxRestartBackoff.
+		RestartBackoff    time.Duration
+		MaxRestartBackoff time.Duration
 	}
 }
 
@@ -24,13 +117,61 @@ func DefaultConfig() *Config {
 
 	// Server configuration
 	cfg.Server.Port = "8080"
+	cfg.Server.AllowedOrigins = []string{"http://localhost:3000"}
+
+	// Shutdown configuration
+	cfg.Shutdown.Timeout = 30 * time.Second
+	cfg.Shutdown.Functions = "stop"
+
+	// Auth configuration
+	cfg.Auth.SessionTTL = 24 * time.Hour
+	cfg.Auth.AdminUsername = "admin"
 
 	// Registry configuration
 	cfg.Registry.Address = "localhost:5000"
 
 	// Function configuration
-	cfg.Function.PortDetectionTimeout = 10 * time.Second
+	cfg.Function.PortDetectionTimeout = 30 * time.Second
 	cfg.Function.DataDir = "./data"
+	cfg.Function.Runtime = "knative"
+	cfg.Function.IdleTimeout = 15 * time.Minute
+
+	// Port allocation configuration
+	cfg.Ports.Min = 20000
+	cfg.Ports.Max = 20999
+
+	// Schedule configuration
+	cfg.Schedules.Timeout = 5 * time.Minute
+
+	// Asynchronous invocation configuration
+	cfg.Queue.Workers = 4
+	cfg.Queue.MaxAttempts = 5
+	cfg.Queue.RetryBackoff = time.Second
+	cfg.Queue.MaxRetryBackoff = 5 * time.Minute
+	cfg.Queue.Timeout = 5 * time.Minute
+
+	// Health check configuration
+	cfg.Health.CheckType = "tcp"
+	cfg.Health.CheckPath = "/"
+	cfg.Health.CheckInterval = 10 * time.Second
+	cfg.Health.CheckTimeout = 2 * time.Second
+	cfg.Health.FailureThreshold = 3
+	cfg.Health.RestartPolicy = "on-failure"
+	cfg.Health.RestartBackoff = time.Second
+	cfg.Health.MaxRestartBackoff = time.Minute
+
+	// Logging configuration
+	cfg.Logging.Level = "info"
+	cfg.Logging.Format = "json"
+
+	// Tracing configuration
+	cfg.Tracing.Exporter = "none"
+	cfg.Tracing.Endpoint = "http://localhost:4318"
+	cfg.Tracing.SampleRatio = 1
+
+	// Log configuration
+	cfg.Logs.MaxAttempts = 10
+	cfg.Logs.MaxFileSize = 10 << 20
 
 	return cfg
 }
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// settings, such as SERVERLESS_SERVER_PORT for Server.Port
+const EnvPrefix = "SERVERLESS_"
+
+// Redacted replaces the values of secret settings in Settings
+const Redacted = "[REDACTED]"
+
+// setting is one field of a section of the configuration
+type setting struct {
+	section, name string
+	value         reflect.Value
+	secret        bool
+}
+
+// key returns the name of the setting in configuration files, such as
+// function.dataDir
+func (s setting) key() string {
+	return lowerFirst(s.section) + "." + lowerFirst(s.name)
+}
+
+// flagName returns the command-line flag of the setting, such as
+// function.data-dir
+func (s setting) flagName() string {
+	return strings.ToLower(s.section) + "." + strings.Join(words(s.name), "-")
+}
+
+// envName returns the environment variable of the setting, such as
+// SERVERLESS_FUNCTION_DATA_DIR
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(s.section+"_"+strings.Join(words(s.name), "_"))
+}
+
+// settings lists the fields of every section of cfg, in declaration order
+func settings(cfg *Config) []setting {
+	var list []setting
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			list = append(list, setting{
+				section: section.Name,
+				name:    field.Name,
+				value:   sections.Field(i).Field(j),
+				secret:  field.Tag.Get("config") == "secret",
+			})
+		}
+	}
+	return list
+}
+
+// Load returns the default configuration overridden by a configuration
+// file, then by SERVERLESS_* environment variables and finally by
+// command-line flags in args, and validates the result. The file is named
+// by the -config flag or SERVERLESS_CONFIG and may be YAML (.yaml, .yml) or
+// TOML (.toml). Load also returns the arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	cfg := DefaultConfig()
+	list := settings(cfg)
+
+	fs := flag.NewFlagSet("serverless", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "configuration file (.yaml, .yml or .toml)")
+	flags := make(map[string]string)
+	for _, s := range list {
+		name := s.flagName()
+		fs.Func(name, "overrides "+s.key()+" and "+s.envName(), func(v string) error {
+			flags[name] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *file != "" {
+		if err := loadFile(list, *file); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, s := range list {
+		if v, ok := os.LookupEnv(s.envName()); ok {
+			if err := set(s, v); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %v", s.envName(), err)
+			}
+		}
+	}
+	for _, s := range list {
+		if v, ok := flags[s.flagName()]; ok {
+			if err := set(s, v); err != nil {
+				return nil, nil, fmt.Errorf("invalid -%s: %v", s.flagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile applies the settings in a YAML or TOML file. Sections and keys
+// are matched ignoring case, dashes and underscores, so function.dataDir
+// may also be written as function.data_dir.
+func loadFile(list []setting, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+	var sections map[string]map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &sections)
+	case ".toml":
+		err = toml.Unmarshal(data, &sections)
+	default:
+		return fmt.Errorf("unsupported config file %s, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	byKey := make(map[string]setting)
+	for _, s := range list {
+		byKey[normalize(s.section)+"."+normalize(s.name)] = s
+	}
+	for section, values := range sections {
+		for name, v := range values {
+			s, ok := byKey[normalize(section)+"."+normalize(name)]
+			if !ok {
+				return fmt.Errorf("unknown setting %s.%s in %s", section, name, path)
+			}
+			if err := setValue(s, v); err != nil {
+				return fmt.Errorf("invalid %s in %s: %v", s.key(), path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setValue applies a value decoded from a configuration file
+func setValue(s setting, v interface{}) error {
+	if v == nil {
+		return set(s, "")
+	}
+	if list, ok := v.([]interface{}); ok {
+		if s.value.Kind() != reflect.Slice {
+			return errors.New("expected a single value, not a list")
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		s.value.Set(reflect.ValueOf(items))
+		return nil
+	}
+	return set(s, fmt.Sprint(v))
+}
+
+// set parses a value given as text, such as an environment variable. Lists
+// are comma-separated and durations are written like 1m30s.
+func set(s setting, v string) error {
+	v = strings.TrimSpace(v)
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(v)
+	case []string:
+		items := []string{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	case time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case int, int64:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", v)
+		}
+		s.value.SetInt(n)
+	case float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", v)
+		}
+		s.value.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// Settings returns the configuration by section and setting, keyed as in
+// configuration files. Durations are written like 1m30s and the values of
+// secret settings are replaced by Redacted unless they are empty.
+func (c *Config) Settings() map[string]map[string]interface{} {
+	out := make(map[string]map[string]interface{})
+	for _, s := range settings(c) {
+		section := lowerFirst(s.section)
+		if out[section] == nil {
+			out[section] = make(map[string]interface{})
+		}
+		var v interface{} = s.value.Interface()
+		switch value := v.(type) {
+		case time.Duration:
+			v = value.String()
+		case string:
+			if s.secret && value != "" {
+				v = Redacted
+			}
+		}
+		out[section][lowerFirst(s.name)] = v
+	}
+	return out
+}
+
+// Validate checks that the settings are usable, reporting every problem
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	oneOf := func(key, v string, allowed ...string) {
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), v))
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port must be a port number, got %q", c.Server.Port)
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout must be positive")
+	oneOf("shutdown.functions", c.Shutdown.Functions, "stop", "detach")
+	check(c.Auth.SessionTTL > 0, "auth.sessionTTL must be positive")
+	check(c.Auth.AdminUsername != "", "auth.adminUsername must not be empty")
+	oneOf("logging.level", c.Logging.Level, "debug", "info", "warn", "error")
+	oneOf("logging.format", c.Logging.Format, "json", "text")
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "otlp")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+	check(c.Logs.MaxAttempts > 0, "logs.maxAttempts must be positive")
+	check(c.Logs.MaxFileSize > 0, "logs.maxFileSize must be positive")
+	check(c.Registry.Address != "", "registry.address must not be empty")
+	check(c.Function.DataDir != "", "function.dataDir must not be empty")
+	check(c.Function.PortDetectionTimeout > 0, "function.portDetectionTimeout must be positive")
+	oneOf("function.runtime", c.Function.Runtime, "knative", "native")
+	check(c.Function.IdleTimeout >= 0, "function.idleTimeout must not be negative")
+	check(c.Ports.Min > 0 && c.Ports.Max < 65536 && c.Ports.Min <= c.Ports.Max,
+		"ports.min and ports.max must be a range of port numbers, got %d-%d", c.Ports.Min, c.Ports.Max)
+	check(c.Schedules.Timeout > 0, "schedules.timeout must be positive")
+	check(c.Queue.Workers > 0, "queue.workers must be positive")
+	check(c.Queue.MaxAttempts > 0, "queue.maxAttempts must be positive")
+	check(c.Queue.RetryBackoff > 0 && c.Queue.RetryBackoff <= c.Queue.MaxRetryBackoff,
+		"queue.retryBackoff must be positive and at most queue.maxRetryBackoff")
+	check(c.Queue.Timeout > 0, "queue.timeout must be positive")
+	oneOf("health.checkType", c.Health.CheckType, "http", "tcp", "none")
+	check(strings.HasPrefix(c.Health.CheckPath, "/"), "health.checkPath must start with /")
+	check(c.Health.CheckInterval >= time.Second, "health.checkInterval must be at least 1s")
+	check(c.Health.CheckTimeout >= time.Second, "health.checkTimeout must be at least 1s")
+	check(c.Health.FailureThreshold > 0, "health.failureThreshold must be positive")
+	oneOf("health.restartPolicy", c.Health.RestartPolicy, "never", "on-failure", "always")
+	check(c.Health.RestartBackoff > 0 && c.Health.RestartBackoff <= c.Health.MaxRestartBackoff,
+		"health.restartBackoff must be positive and at most health.maxRestartBackoff")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %v", errors.Join(errs...))
+	}
+	return nil
+}
+
+// words splits a field name such as DataDir or SessionTTL into its
+// lowercase words
+func words(name string) []string {
+	var out []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		upper := unicode.IsUpper(runes[i])
+		// A new word starts at an upper case letter that follows a lower
+		// case one, or that ends an acronym followed by a lower case one
+		if upper && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			out = append(out, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+	}
+	return append(out, strings.ToLower(string(runes[start:])))
+}
+
+// lowerFirst turns a field name such as SessionTTL into sessionTTL
+func lowerFirst(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// normalize drops case, dashes and underscores from a file key
+func normalize(key string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+}
@@ -0,0 +1,325 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a configuration file named name and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "serverless.yaml", `
+server:
+  port: "9000"
+  allowedOrigins: [https://a.example, https://b.example]
+function:
+  data_dir: /srv/functions
+  idle-timeout: 5m
+queue:
+  workers: 8
+`)
+	tomlFile := writeFile(t, "serverless.toml", `
+[Server]
+Port = "9000"
+
+[Tracing]
+SampleRatio = 0.25
+`)
+
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(*Config)
+	}{
+		{
+			name: "defaults",
+			want: func(c *Config) {},
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", yamlFile},
+			want: func(c *Config) {
+				c.Server.Port = "9000"
+				c.Server.AllowedOrigins = []string{"https://a.example", "https://b.example"}
+				c.Function.DataDir = "/srv/functions"
+				c.Function.IdleTimeout = 5 * time.Minute
+				c.Queue.Workers = 8
+			},
+		},
+		{
+			name: "toml file named by the environment",
+			env:  map[string]string{"SERVERLESS_CONFIG": tomlFile},
+			want: func(c *Config) {
+				c.Server.Port = "9000"
+				c.Tracing.SampleRatio = 0.25
+			},
+		},
+		{
+			name: "environment over file",
+			env: map[string]string{
+				"SERVERLESS_SERVER_PORT":            "9001",
+				"SERVERLESS_SERVER_ALLOWED_ORIGINS": "https://c.example, ,https://d.example",
+			},
+			args: []string{"-config", yamlFile},
+			want: func(c *Config) {
+				c.Server.Port = "9001"
+				c.Server.AllowedOrigins = []string{"https://c.example", "https://d.example"}
+				c.Function.DataDir = "/srv/functions"
+				c.Function.IdleTimeout = 5 * time.Minute
+				c.Queue.Workers = 8
+			},
+		},
+		{
+			name: "flags over environment",
+			env:  map[string]string{"SERVERLESS_SERVER_PORT": "9001", "SERVERLESS_QUEUE_WORKERS": "2"},
+			args: []string{"-config", yamlFile, "-server.port", "9002", "-function.idle-timeout=0s"},
+			want: func(c *Config) {
+				c.Server.Port = "9002"
+				c.Server.AllowedOrigins = []string{"https://a.example", "https://b.example"}
+				c.Function.DataDir = "/srv/functions"
+				c.Function.IdleTimeout = 0
+				c.Queue.Workers = 2
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, rest, err := Load(append(tt.args, "serve"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := DefaultConfig()
+			tt.want(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v\nwant %+v", got, want)
+			}
+			if !reflect.DeepEqual(rest, []string{"serve"}) {
+				t.Fatalf("got arguments %q, want [serve]", rest)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{
+			name: "unknown setting",
+			args: []string{"-config", writeFile(t, "unknown.yaml", "server:\n  host: localhost\n")},
+			want: "unknown setting server.host",
+		},
+		{
+			name: "unsupported file",
+			args: []string{"-config", writeFile(t, "serverless.json", "{}")},
+			want: "unsupported config file",
+		},
+		{
+			name: "invalid file value",
+			args: []string{"-config", writeFile(t, "invalid.yaml", "queue:\n  workers: [1, 2]\n")},
+			want: "invalid queue.workers",
+		},
+		{
+			name: "invalid environment variable",
+			env:  map[string]string{"SERVERLESS_FUNCTION_PORT_DETECTION_TIMEOUT": "soon"},
+			want: "invalid SERVERLESS_FUNCTION_PORT_DETECTION_TIMEOUT",
+		},
+		{
+			name: "invalid flag",
+			args: []string{"-ports.min", "low"},
+			want: "invalid -ports.min",
+		},
+		{
+			name: "invalid configuration",
+			args: []string{"-logging.level", "verbose"},
+			want: `logging.level must be one of debug, info, warn, error, got "verbose"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := Load(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// lookup returns the setting with a key such as server.port
+func lookup(t *testing.T, cfg *Config, key string) setting {
+	t.Helper()
+	for _, s := range settings(cfg) {
+		if s.key() == key {
+			return s
+		}
+	}
+	t.Fatalf("no setting %s", key)
+	return setting{}
+}
+
+func TestSet(t *testing.T) {
+	for _, tt := range []struct {
+		key, value string
+		want       interface{}
+		err        bool
+	}{
+		{key: "server.port", value: " 9000 ", want: "9000"},
+		{key: "server.allowedOrigins", value: "a, b,,c ", want: []string{"a", "b", "c"}},
+		{key: "server.allowedOrigins", value: "", want: []string{}},
+		{key: "function.portDetectionTimeout", value: "1m30s", want: 90 * time.Second},
+		{key: "function.portDetectionTimeout", value: "90", err: true},
+		{key: "ports.min", value: "21000", want: 21000},
+		{key: "ports.min", value: "2.5", err: true},
+		{key: "logs.maxFileSize", value: "1048576", want: int64(1 << 20)},
+		{key: "tracing.sampleRatio", value: "0.1", want: 0.1},
+		{key: "tracing.sampleRatio", value: "most", err: true},
+	} {
+		cfg := DefaultConfig()
+		s := lookup(t, cfg, tt.key)
+		err := set(s, tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("set %s to %q: got %v, want an error", tt.key, tt.value, s.value.Interface())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("set %s to %q: %v", tt.key, tt.value, err)
+			continue
+		}
+		if got := s.value.Interface(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("set %s to %q: got %#v, want %#v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	for _, tt := range []struct {
+		key   string
+		value interface{}
+		want  interface{}
+		err   bool
+	}{
+		// Values as decoded from YAML and TOML
+		{key: "ports.max", value: 25000, want: 25000},
+		{key: "ports.max", value: int64(25000), want: 25000},
+		{key: "tracing.sampleRatio", value: 0.5, want: 0.5},
+		{key: "server.allowedOrigins", value: []interface{}{"a", "b"}, want: []string{"a", "b"}},
+		{key: "server.port", value: []interface{}{"9000"}, err: true},
+		// An empty value clears the setting
+		{key: "auth.adminPassword", value: nil, want: ""},
+		{key: "function.portDetectionTimeout", value: nil, err: true},
+	} {
+		cfg := DefaultConfig()
+		cfg.Auth.AdminPassword = "from the defaults"
+		s := lookup(t, cfg, tt.key)
+		err := setValue(s, tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("set %s to %#v: got %v, want an error", tt.key, tt.value, s.value.Interface())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("set %s to %#v: %v", tt.key, tt.value, err)
+			continue
+		}
+		if got := s.value.Interface(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("set %s to %#v: got %#v, want %#v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default configuration: %v", err)
+	}
+
+	for _, tt := range []struct {
+		change func(*Config)
+		want   []string
+	}{
+		{func(c *Config) { c.Server.Port = "http" }, []string{`server.port must be a port number, got "http"`}},
+		{func(c *Config) { c.Server.Port = "65536" }, []string{"server.port must be a port number"}},
+		{func(c *Config) { c.Shutdown.Functions = "kill" }, []string{"shutdown.functions must be one of stop, detach"}},
+		{func(c *Config) { c.Tracing.SampleRatio = 1.5 }, []string{"tracing.sampleRatio must be between 0 and 1"}},
+		{func(c *Config) { c.Ports.Min, c.Ports.Max = 30000, 20000 }, []string{"got 30000-20000"}},
+		{func(c *Config) { c.Queue.RetryBackoff = time.Hour }, []string{"queue.retryBackoff must be positive and at most queue.maxRetryBackoff"}},
+		{func(c *Config) { c.Health.CheckPath = "healthz" }, []string{"health.checkPath must start with /"}},
+		// Every problem is reported
+		{func(c *Config) {
+			c.Logging.Format = "xml"
+			c.Queue.Workers = 0
+			c.Health.CheckInterval = time.Millisecond
+		}, []string{"logging.format", "queue.workers must be positive", "health.checkInterval must be at least 1s"}},
+	} {
+		cfg := DefaultConfig()
+		tt.change(cfg)
+		err := cfg.Validate()
+		if err == nil {
+			t.Errorf("got no error, want %q", tt.want)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %v, want %q", err, want)
+			}
+		}
+	}
+}
+
+func TestSettingsRedactsSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth.SessionSecret = "session secret"
+	cfg.Secrets.Key = "secrets key"
+
+	settings := cfg.Settings()
+	for _, tt := range []struct {
+		section, key string
+		want         interface{}
+	}{
+		{"auth", "sessionSecret", Redacted},
+		{"secrets", "key", Redacted},
+		// Empty secrets show that they are not set
+		{"auth", "adminPassword", ""},
+		{"auth", "adminUsername", "admin"},
+		{"function", "portDetectionTimeout", "30s"},
+		{"server", "allowedOrigins", []string{"http://localhost:3000"}},
+	} {
+		if got := settings[tt.section][tt.key]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.%s: got %#v, want %#v", tt.section, tt.key, got, tt.want)
+		}
+	}
+
+	// The output of /config
+	out, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"session secret", "secrets key"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("%q in %s", secret, out)
+		}
+	}
+}
@@ -1,73 +1,120 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"main/types"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// InitDB opens the SQLite database in dataDir and applies pending
+// migrations
+func InitDB(dataDir string) (*sql.DB, error) {
+	conn, err := Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
 
-// InitDB initializes the SQLite database
-func InitDB() error {
+// Open opens the SQLite database in dataDir without migrating it
+func Open(dataDir string) (*sql.DB, error) {
 	// Create data directory if it doesn't exist
-	dataDir := "./data"
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return fmt.Errorf("error creating data directory: %v", err)
+		return nil, fmt.Errorf("error creating data directory: %v", err)
 	}
 
 	// Open database connection
 	dbPath := filepath.Join(dataDir, "deployments.db")
-	var err error
-	DB, err = sql.Open("sqlite3", dbPath)
+	conn, err := sql.Open(tracedDriverName, dbPath)
 	if err != nil {
-		return fmt.Errorf("error opening database: %v", err)
-	}
-
-	// Create deployments table
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS deployments (
-			id TEXT PRIMARY KEY,
-			name TEXT UNIQUE NOT NULL,
-			language TEXT NOT NULL,
-			status TEXT NOT NULL,
-			created_at TEXT NOT NULL,
-			port TEXT,
-			built BOOLEAN NOT NULL DEFAULT FALSE
-		)
-	`)
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	return conn, nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SQLiteStore is the DeploymentStore backed by the SQLite database
+type SQLiteStore struct {
+	conn *sql.DB
+	q    querier
+}
+
+func NewSQLiteStore(conn *sql.DB) *SQLiteStore {
+	return &SQLiteStore{conn: conn, q: conn}
+}
+
+// WithinTx runs fn in a database transaction. Calls nested in an existing
+// transaction join it.
+func (s *SQLiteStore) WithinTx(ctx context.Context, fn func(DeploymentStore) error) error {
+	if s.conn == nil {
+		return fn(s)
+	}
+	tx, err := s.conn.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("error creating deployments table: %v", err)
+		return fmt.Errorf("error starting transaction: %v", err)
 	}
+	defer tx.Rollback()
 
+	if err := fn(&SQLiteStore{q: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
 	return nil
 }
 
-// CreateDeployment inserts a new deployment into the database
-func CreateDeployment(d types.Deployment) error {
-	_, err := DB.Exec(`
-		INSERT INTO deployments (id, name, language, status, created_at, port, built)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, d.ID, d.Name, d.Language, d.Status, d.CreatedAt, d.Port, d.Built)
+// deploymentColumns are the columns read by scanDeployment
+const deploymentColumns = `id, name, language, status, created_at, port, built, pid, restart_policy,
+	health_check_type, health_check_path, health_check_interval, health_check_timeout, health_check_threshold`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDeployment(row scanner) (types.Deployment, error) {
+	var d types.Deployment
+	hc := &d.HealthCheck
+	err := row.Scan(&d.ID, &d.Name, &d.Language, &d.Status, &d.CreatedAt, &d.Port, &d.Built, &d.PID, &d.RestartPolicy,
+		&hc.Type, &hc.Path, &hc.IntervalSeconds, &hc.TimeoutSeconds, &hc.FailureThreshold)
+	return d, err
+}
+
+// Create inserts a new deployment into the database
+func (s *SQLiteStore) Create(ctx context.Context, d types.Deployment) error {
+	hc := d.HealthCheck
+	_, err := s.q.ExecContext(ctx, `
+		INSERT INTO deployments (`+deploymentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.ID, d.Name, d.Language, d.Status, d.CreatedAt, d.Port, d.Built, d.PID, d.RestartPolicy,
+		hc.Type, hc.Path, hc.IntervalSeconds, hc.TimeoutSeconds, hc.FailureThreshold)
 	if err != nil {
 		return fmt.Errorf("error creating deployment: %v", err)
 	}
 	return nil
 }
 
-// GetDeployment retrieves a deployment by name
-func GetDeployment(name string) (*types.Deployment, error) {
-	var d types.Deployment
-	err := DB.QueryRow(`
-		SELECT id, name, language, status, created_at, port, built
+// Get retrieves a deployment by name
+func (s *SQLiteStore) Get(ctx context.Context, name string) (*types.Deployment, error) {
+	d, err := scanDeployment(s.q.QueryRowContext(ctx, `
+		SELECT `+deploymentColumns+`
 		FROM deployments
 		WHERE name = ?
-	`, name).Scan(&d.ID, &d.Name, &d.Language, &d.Status, &d.CreatedAt, &d.Port, &d.Built)
+	`, name))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -77,23 +124,38 @@ func GetDeployment(name string) (*types.Deployment, error) {
 	return &d, nil
 }
 
-// UpdateDeployment updates a deployment's status and port
-func UpdateDeployment(d types.Deployment) error {
-	_, err := DB.Exec(`
+// Update updates a deployment's port, built flag and process ID
+func (s *SQLiteStore) Update(ctx context.Context, d types.Deployment) error {
+	_, err := s.q.ExecContext(ctx, `
 		UPDATE deployments
-		SET status = ?, port = ?, built = ?
+		SET port = ?, built = ?, pid = ?
 		WHERE name = ?
-	`, d.Status, d.Port, d.Built, d.Name)
+	`, d.Port, d.Built, d.PID, d.Name)
 	if err != nil {
 		return fmt.Errorf("error updating deployment: %v", err)
 	}
 	return nil
 }
 
-// GetAllDeployments retrieves all deployments
-func GetAllDeployments() ([]types.Deployment, error) {
-	rows, err := DB.Query(`
-		SELECT id, name, language, status, created_at, port, built
+// UpdateHealth saves a deployment's restart policy and health check
+func (s *SQLiteStore) UpdateHealth(ctx context.Context, d types.Deployment) error {
+	hc := d.HealthCheck
+	_, err := s.q.ExecContext(ctx, `
+		UPDATE deployments
+		SET restart_policy = ?, health_check_type = ?, health_check_path = ?,
+			health_check_interval = ?, health_check_timeout = ?, health_check_threshold = ?
+		WHERE name = ?
+	`, d.RestartPolicy, hc.Type, hc.Path, hc.IntervalSeconds, hc.TimeoutSeconds, hc.FailureThreshold, d.Name)
+	if err != nil {
+		return fmt.Errorf("error updating deployment health settings: %v", err)
+	}
+	return nil
+}
+
+// List retrieves all deployments
+func (s *SQLiteStore) List(ctx context.Context) ([]types.Deployment, error) {
+	rows, err := s.q.QueryContext(ctx, `
+		SELECT `+deploymentColumns+`
 		FROM deployments
 		ORDER BY created_at DESC
 	`)
@@ -104,8 +166,7 @@ func GetAllDeployments() ([]types.Deployment, error) {
 
 	var deployments []types.Deployment
 	for rows.Next() {
-		var d types.Deployment
-		err := rows.Scan(&d.ID, &d.Name, &d.Language, &d.Status, &d.CreatedAt, &d.Port, &d.Built)
+		d, err := scanDeployment(rows)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning deployment: %v", err)
 		}
@@ -117,22 +178,105 @@ func GetAllDeployments() ([]types.Deployment, error) {
 	return deployments, nil
 }
 
-// DeleteDeployment deletes a deployment from the database
-func DeleteDeployment(name string) error {
-	_, err := DB.Exec("DELETE FROM deployments WHERE name = ?", name)
+// Delete deletes a deployment from the database
+func (s *SQLiteStore) Delete(ctx context.Context, name string) error {
+	return s.WithinTx(ctx, func(tx DeploymentStore) error {
+		q := tx.(*SQLiteStore).q
+		if _, err := q.ExecContext(ctx, "DELETE FROM deployments WHERE name = ?", name); err != nil {
+			return fmt.Errorf("error deleting deployment: %v", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM cold_starts WHERE deployment_name = ?", name); err != nil {
+			return fmt.Errorf("error deleting cold starts: %v", err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM deployment_transitions WHERE deployment_name = ?", name); err != nil {
+			return fmt.Errorf("error deleting status history: %v", err)
+		}
+		return nil
+	})
+}
+
+// RecordColdStart stores the duration of an on-demand start
+func (s *SQLiteStore) RecordColdStart(ctx context.Context, name string, c types.ColdStart) error {
+	_, err := s.q.ExecContext(ctx, `
+		INSERT INTO cold_starts (deployment_name, started_at, duration_ms)
+		VALUES (?, ?, ?)
+	`, name, c.StartedAt, c.DurationMs)
 	if err != nil {
-		return fmt.Errorf("error deleting deployment: %v", err)
+		return fmt.Errorf("error recording cold start: %v", err)
 	}
 	return nil
 }
 
-// Deployment represents a function deployment
-type Deployment struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Language  string `json:"language"`
-	Status    string `json:"status"` // Can be: "Stopped", "Running", "Failed", "Building", "Built"
-	CreatedAt string `json:"createdAt"`
-	Port      string `json:"port,omitempty"`
-	Built     bool   `json:"built"`
+// ColdStarts retrieves the most recent cold starts of a deployment
+func (s *SQLiteStore) ColdStarts(ctx context.Context, name string, limit int) ([]types.ColdStart, error) {
+	rows, err := s.q.QueryContext(ctx, `
+		SELECT started_at, duration_ms
+		FROM cold_starts
+		WHERE deployment_name = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying cold starts: %v", err)
+	}
+	defer rows.Close()
+
+	var coldStarts []types.ColdStart
+	for rows.Next() {
+		var c types.ColdStart
+		if err := rows.Scan(&c.StartedAt, &c.DurationMs); err != nil {
+			return nil, fmt.Errorf("error scanning cold start: %v", err)
+		}
+		coldStarts = append(coldStarts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cold starts: %v", err)
+	}
+	return coldStarts, nil
+}
+
+// RecordTransition updates a deployment's status and logs the change
+func (s *SQLiteStore) RecordTransition(ctx context.Context, t types.StatusTransition) error {
+	return s.WithinTx(ctx, func(tx DeploymentStore) error {
+		q := tx.(*SQLiteStore).q
+		if _, err := q.ExecContext(ctx, "UPDATE deployments SET status = ? WHERE name = ?", t.To, t.Deployment); err != nil {
+			return fmt.Errorf("error updating deployment status: %v", err)
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO deployment_transitions (deployment_name, from_status, to_status, reason, created_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, t.Deployment, t.From, t.To, t.Reason, t.At)
+		if err != nil {
+			return fmt.Errorf("error recording status transition: %v", err)
+		}
+		return nil
+	})
+}
+
+// Transitions retrieves the most recent status changes of a deployment
+func (s *SQLiteStore) Transitions(ctx context.Context, name string, limit int) ([]types.StatusTransition, error) {
+	rows, err := s.q.QueryContext(ctx, `
+		SELECT deployment_name, from_status, to_status, reason, created_at
+		FROM deployment_transitions
+		WHERE deployment_name = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying status history: %v", err)
+	}
+	defer rows.Close()
+
+	transitions := []types.StatusTransition{}
+	for rows.Next() {
+		var t types.StatusTransition
+		if err := rows.Scan(&t.Deployment, &t.From, &t.To, &t.Reason, &t.At); err != nil {
+			return nil, fmt.Errorf("error scanning status transition: %v", err)
+		}
+		transitions = append(transitions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating status history: %v", err)
+	}
+	return transitions, nil
 }
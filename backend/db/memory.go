@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"main/types"
+)
+
+// MemoryStore is a DeploymentStore that keeps everything in memory, for
+// tests
+type MemoryStore struct {
+	mu          sync.Mutex
+	deployments map[string]types.Deployment
+	coldStarts  map[string][]types.ColdStart
+	transitions map[string][]types.StatusTransition
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		deployments: make(map[string]types.Deployment),
+		coldStarts:  make(map[string][]types.ColdStart),
+		transitions: make(map[string][]types.StatusTransition),
+	}
+}
+
+// WithinTx runs fn against a copy of the store and keeps the copy's changes
+// only if fn succeeds. Other callers wait until fn returns.
+func (s *MemoryStore) WithinTx(ctx context.Context, fn func(DeploymentStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := NewMemoryStore()
+	for name, d := range s.deployments {
+		tx.deployments[name] = d
+	}
+	for name, c := range s.coldStarts {
+		tx.coldStarts[name] = append([]types.ColdStart(nil), c...)
+	}
+	for name, t := range s.transitions {
+		tx.transitions[name] = append([]types.StatusTransition(nil), t...)
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.deployments, s.coldStarts, s.transitions = tx.deployments, tx.coldStarts, tx.transitions
+	return nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, d types.Deployment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.deployments {
+		if existing.ID == d.ID || existing.Name == d.Name {
+			return fmt.Errorf("error creating deployment: %s already exists", d.Name)
+		}
+	}
+	s.deployments[d.Name] = d
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, name string) (*types.Deployment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deployments[name]
+	if !ok {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, d types.Deployment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.deployments[d.Name]
+	if !ok {
+		return nil
+	}
+	existing.Port, existing.Built, existing.PID = d.Port, d.Built, d.PID
+	s.deployments[d.Name] = existing
+	return nil
+}
+
+func (s *MemoryStore) UpdateHealth(ctx context.Context, d types.Deployment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.deployments[d.Name]
+	if !ok {
+		return nil
+	}
+	existing.RestartPolicy, existing.HealthCheck = d.RestartPolicy, d.HealthCheck
+	s.deployments[d.Name] = existing
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]types.Deployment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deployments []types.Deployment
+	for _, d := range s.deployments {
+		deployments = append(deployments, d)
+	}
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].CreatedAt > deployments[j].CreatedAt })
+	return deployments, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deployments, name)
+	delete(s.coldStarts, name)
+	delete(s.transitions, name)
+	return nil
+}
+
+func (s *MemoryStore) RecordColdStart(ctx context.Context, name string, c types.ColdStart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coldStarts[name] = append(s.coldStarts[name], c)
+	return nil
+}
+
+func (s *MemoryStore) ColdStarts(ctx context.Context, name string, limit int) ([]types.ColdStart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := s.coldStarts[name]
+	var coldStarts []types.ColdStart
+	for i := len(all) - 1; i >= 0 && len(coldStarts) < limit; i-- {
+		coldStarts = append(coldStarts, all[i])
+	}
+	return coldStarts, nil
+}
+
+func (s *MemoryStore) RecordTransition(ctx context.Context, t types.StatusTransition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deployments[t.Deployment]
+	if !ok {
+		return nil
+	}
+	d.Status = t.To
+	s.deployments[t.Deployment] = d
+	s.transitions[t.Deployment] = append(s.transitions[t.Deployment], t)
+	return nil
+}
+
+func (s *MemoryStore) Transitions(ctx context.Context, name string, limit int) ([]types.StatusTransition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := s.transitions[name]
+	transitions := []types.StatusTransition{}
+	for i := len(all) - 1; i >= 0 && len(transitions) < limit; i-- {
+		transitions = append(transitions, all[i])
+	}
+	return transitions, nil
+}
@@ -0,0 +1,17 @@
+-- Tables created by InitDB before migrations existed, hence IF NOT EXISTS
+CREATE TABLE IF NOT EXISTS deployments (
+	id TEXT PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	language TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	port TEXT,
+	built BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS cold_starts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deployment_name TEXT NOT NULL,
+	started_at TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS deployment_env (
+	deployment_name TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	secret BOOLEAN NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (deployment_name, key)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS revisions (
+	id TEXT PRIMARY KEY,
+	deployment_name TEXT NOT NULL,
+	number INTEGER NOT NULL,
+	code_file TEXT NOT NULL,
+	package_file TEXT NOT NULL,
+	code TEXT NOT NULL,
+	package TEXT NOT NULL,
+	build_status TEXT NOT NULL,
+	image TEXT NOT NULL DEFAULT '',
+	rollback_of INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL,
+	UNIQUE (deployment_name, number)
+);
@@ -0,0 +1,2 @@
+-- Process ID of a deployment's function, used to find it again after a restart
+ALTER TABLE deployments ADD COLUMN pid INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+CREATE TABLE deployment_transitions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	deployment_name TEXT NOT NULL,
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+
+CREATE INDEX deployment_transitions_deployment ON deployment_transitions (deployment_name);
@@ -0,0 +1,14 @@
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	deployment_name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	exit_code INTEGER,
+	output TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	started_at TEXT NOT NULL DEFAULT '',
+	finished_at TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX jobs_deployment ON jobs (deployment_name);
@@ -0,0 +1,8 @@
+-- Restart policy and health check of each deployment. Empty and zero values
+-- fall back to the configured defaults.
+ALTER TABLE deployments ADD COLUMN restart_policy TEXT NOT NULL DEFAULT '';
+ALTER TABLE deployments ADD COLUMN health_check_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE deployments ADD COLUMN health_check_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE deployments ADD COLUMN health_check_interval INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE deployments ADD COLUMN health_check_timeout INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE deployments ADD COLUMN health_check_threshold INTEGER NOT NULL DEFAULT 0;
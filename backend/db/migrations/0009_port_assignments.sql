@@ -0,0 +1,7 @@
+-- Ports assigned to deployments by the port allocator. A deployment keeps
+-- its port across restarts until the port is taken by another process.
+CREATE TABLE port_assignments (
+	deployment_name TEXT PRIMARY KEY,
+	port INTEGER NOT NULL UNIQUE,
+	assigned_at TEXT NOT NULL
+);
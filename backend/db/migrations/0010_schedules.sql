@@ -0,0 +1,26 @@
+-- Cron schedules that invoke a deployment's function over HTTP
+CREATE TABLE schedules (
+	id TEXT PRIMARY KEY,
+	deployment_name TEXT NOT NULL,
+	cron TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	body TEXT NOT NULL DEFAULT '',
+	enabled INTEGER NOT NULL DEFAULT 1,
+	created_at TEXT NOT NULL
+);
+
+CREATE INDEX schedules_deployment ON schedules (deployment_name);
+
+-- One row per scheduled invocation
+CREATE TABLE schedule_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	schedule_id TEXT NOT NULL,
+	started_at TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	latency_ms INTEGER NOT NULL,
+	response TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX schedule_runs_schedule ON schedule_runs (schedule_id);
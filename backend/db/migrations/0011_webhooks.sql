@@ -0,0 +1,33 @@
+-- Webhook endpoints that forward external requests to a deployment's function
+CREATE TABLE webhooks (
+	id TEXT PRIMARY KEY,
+	deployment_name TEXT NOT NULL,
+	token TEXT NOT NULL UNIQUE,
+	path TEXT NOT NULL,
+	-- Encrypted with the secrets key
+	secret TEXT NOT NULL DEFAULT '',
+	signature_header TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL
+);
+
+CREATE INDEX webhooks_deployment ON webhooks (deployment_name);
+
+-- One row per request received by a webhook, or replayed from the API
+CREATE TABLE webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id TEXT NOT NULL,
+	received_at TEXT NOT NULL,
+	method TEXT NOT NULL,
+	headers TEXT NOT NULL,
+	query TEXT NOT NULL DEFAULT '',
+	body BLOB NOT NULL,
+	body_sha256 TEXT NOT NULL,
+	verified INTEGER NOT NULL DEFAULT 0,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	latency_ms INTEGER NOT NULL DEFAULT 0,
+	response TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	replay_of INTEGER
+);
+
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (webhook_id);
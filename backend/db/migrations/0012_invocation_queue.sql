@@ -0,0 +1,40 @@
+-- Asynchronous invocations waiting to be delivered to a function, and the
+-- most recent delivered ones
+CREATE TABLE invocations (
+	id TEXT PRIMARY KEY,
+	deployment_name TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	headers TEXT NOT NULL,
+	body BLOB NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	-- Unix milliseconds, so that due invocations can be compared
+	next_attempt_at INTEGER NOT NULL,
+	last_status_code INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	response TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+
+CREATE INDEX invocations_due ON invocations (status, next_attempt_at);
+CREATE INDEX invocations_deployment ON invocations (deployment_name);
+
+-- Invocations that could not be delivered within the maximum number of
+-- attempts
+CREATE TABLE dead_letters (
+	id TEXT PRIMARY KEY,
+	deployment_name TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	headers TEXT NOT NULL,
+	body BLOB NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_status_code INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	failed_at TEXT NOT NULL
+);
+
+CREATE INDEX dead_letters_deployment ON dead_letters (deployment_name);
@@ -0,0 +1,23 @@
+-- Deployments subscribed to topics, with delivery metrics per subscription
+CREATE TABLE topic_subscriptions (
+	id TEXT PRIMARY KEY,
+	topic TEXT NOT NULL,
+	deployment_name TEXT NOT NULL,
+	path TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	published INTEGER NOT NULL DEFAULT 0,
+	delivered INTEGER NOT NULL DEFAULT 0,
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	dead_lettered INTEGER NOT NULL DEFAULT 0,
+	total_latency_ms INTEGER NOT NULL DEFAULT 0,
+	last_delivered_at TEXT NOT NULL DEFAULT '',
+	last_error TEXT NOT NULL DEFAULT '',
+	UNIQUE (topic, deployment_name, path)
+);
+
+CREATE INDEX topic_subscriptions_deployment ON topic_subscriptions (deployment_name);
+
+-- Invocations published to a topic remember the subscription they were
+-- published for
+ALTER TABLE invocations ADD COLUMN subscription_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE dead_letters ADD COLUMN subscription_id TEXT NOT NULL DEFAULT '';
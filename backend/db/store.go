@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+
+	"main/types"
+)
+
+// DeploymentStore persists deployments and their cold start history
+type DeploymentStore interface {
+	// Create inserts a new deployment
+	Create(ctx context.Context, d types.Deployment) error
+	// Get retrieves a deployment by name, or nil if it does not exist
+	Get(ctx context.Context, name string) (*types.Deployment, error)
+	// Update saves a deployment's port, built flag and process ID. The status
+	// only changes through RecordTransition.
+	Update(ctx context.Context, d types.Deployment) error
+	// UpdateHealth saves a deployment's restart policy and health check
+	UpdateHealth(ctx context.Context, d types.Deployment) error
+	// List returns every deployment, newest first
+	List(ctx context.Context) ([]types.Deployment, error)
+	// Delete removes a deployment and its cold start and status history
+	Delete(ctx context.Context, name string) error
+
+	// RecordTransition sets a deployment's status to t.To and appends t to
+	// its status history
+	RecordTransition(ctx context.Context, t types.StatusTransition) error
+	// Transitions returns the most recent status changes of a deployment,
+	// newest first
+	Transitions(ctx context.Context, name string, limit int) ([]types.StatusTransition, error)
+
+	// RecordColdStart stores the duration of an on-demand start
+	RecordColdStart(ctx context.Context, name string, c types.ColdStart) error
+	// ColdStarts returns the most recent cold starts of a deployment
+	ColdStarts(ctx context.Context, name string, limit int) ([]types.ColdStart, error)
+
+	// WithinTx runs fn against a store whose changes are applied together
+	// if fn returns nil and discarded otherwise. fn must only use the store
+	// it is given.
+	WithinTx(ctx context.Context, fn func(DeploymentStore) error) error
+}
+
+var (
+	_ DeploymentStore = (*SQLiteStore)(nil)
+	_ DeploymentStore = (*MemoryStore)(nil)
+)
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"main/tracing"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDriverName is the SQLite driver that records a span for every
+// statement run with a context carrying a recorded span
+const tracedDriverName = "sqlite3-traced"
+
+func init() {
+	sql.Register(tracedDriverName, tracedDriver{&sqlite3.SQLiteDriver{}})
+}
+
+type tracedDriver struct {
+	driver.Driver
+}
+
+func (d tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return tracedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// tracedConn wraps a SQLite connection. Statements run without a context,
+// or outside of a traced operation, are not recorded so that background
+// work does not start traces of its own.
+type tracedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span, ok := startStatement(ctx, "exec", query)
+	if !ok {
+		return c.SQLiteConn.ExecContext(ctx, query, args)
+	}
+	res, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	tracing.End(span, err)
+	return res, err
+}
+
+func (c tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span, ok := startStatement(ctx, "query", query)
+	if !ok {
+		return c.SQLiteConn.QueryContext(ctx, query, args)
+	}
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	tracing.End(span, err)
+	return rows, err
+}
+
+// startStatement starts a client span for a statement if ctx is part of a
+// recorded trace
+func startStatement(ctx context.Context, op, query string) (context.Context, trace.Span, bool) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ctx, nil, false
+	}
+	query = strings.Join(strings.Fields(query), " ")
+	ctx, span := tracing.Start(ctx, "db."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.statement", query),
+		))
+	return ctx, span, true
+}
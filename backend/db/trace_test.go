@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"main/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStatementSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	conn, err := InitDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := NewSQLiteStore(conn)
+
+	// Statements outside of a trace are not recorded
+	if _, err := store.List(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recorder.Ended()); n != 0 {
+		t.Fatalf("got %d spans without a trace, want 0", n)
+	}
+
+	ctx, request := tracing.Start(context.Background(), "GET /deployments/{name}")
+	if _, err := store.Get(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the statement and the request", len(spans))
+	}
+	statement := spans[0]
+	if statement.Name() != "db.query" {
+		t.Fatalf("got span %q, want db.query", statement.Name())
+	}
+	if statement.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Fatalf("statement span is not a child of the request span")
+	}
+}
@@ -0,0 +1,194 @@
+package envvars
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"main/types"
+)
+
+// ErrNoKey is returned when a secret is stored without an encryption key
+var ErrNoKey = errors.New("no secrets key configured")
+
+// Store keeps per-deployment environment variables in SQLite. Secret values
+// are encrypted with AES-256-GCM before they are written.
+type Store struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// NewStore returns a store backed by the deployment_env table. key may be empty,
+// in which case plain variables work but secrets cannot be stored.
+func NewStore(db *sql.DB, key string) (*Store, error) {
+	s := &Store{db: db}
+	if key != "" {
+		// Derive a 256-bit key from whatever passphrase was configured
+		sum := sha256.Sum256([]byte(key))
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			return nil, fmt.Errorf("error creating cipher: %v", err)
+		}
+		s.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("error creating cipher: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Set creates or replaces a variable
+func (s *Store) Set(ctx context.Context, name string, v types.EnvVar) error {
+	value := v.Value
+	if v.Secret {
+		var err error
+		if value, err = s.Encrypt(value); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO deployment_env (deployment_name, key, value, secret)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (deployment_name, key) DO UPDATE SET value = excluded.value, secret = excluded.secret
+	`, name, v.Key, value, v.Secret)
+	if err != nil {
+		return fmt.Errorf("error saving environment variable: %v", err)
+	}
+	return nil
+}
+
+// Get retrieves a single decrypted variable, or nil if it does not exist
+func (s *Store) Get(ctx context.Context, name, key string) (*types.EnvVar, error) {
+	var v types.EnvVar
+	err := s.db.QueryRowContext(ctx, `
+		SELECT key, value, secret FROM deployment_env
+		WHERE deployment_name = ? AND key = ?
+	`, name, key).Scan(&v.Key, &v.Value, &v.Secret)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting environment variable: %v", err)
+	}
+	if v.Secret {
+		if v.Value, err = s.Decrypt(v.Value); err != nil {
+			return nil, err
+		}
+	}
+	return &v, nil
+}
+
+// List returns the decrypted variables of a deployment sorted by key
+func (s *Store) List(ctx context.Context, name string) ([]types.EnvVar, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT key, value, secret FROM deployment_env
+		WHERE deployment_name = ?
+		ORDER BY key
+	`, name)
+	if err != nil {
+		return nil, fmt.Errorf("error querying environment variables: %v", err)
+	}
+	defer rows.Close()
+
+	vars := []types.EnvVar{}
+	for rows.Next() {
+		var v types.EnvVar
+		if err := rows.Scan(&v.Key, &v.Value, &v.Secret); err != nil {
+			return nil, fmt.Errorf("error scanning environment variable: %v", err)
+		}
+		if v.Secret {
+			if v.Value, err = s.Decrypt(v.Value); err != nil {
+				return nil, err
+			}
+		}
+		vars = append(vars, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating environment variables: %v", err)
+	}
+	return vars, nil
+}
+
+// Environ returns the variables of a deployment as KEY=value pairs
+func (s *Store) Environ(ctx context.Context, name string) ([]string, error) {
+	vars, err := s.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	environ := make([]string, 0, len(vars))
+	for _, v := range vars {
+		environ = append(environ, v.Key+"="+v.Value)
+	}
+	return environ, nil
+}
+
+// Delete removes a variable. It reports whether the variable existed.
+func (s *Store) Delete(ctx context.Context, name, key string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM deployment_env WHERE deployment_name = ? AND key = ?", name, key)
+	if err != nil {
+		return false, fmt.Errorf("error deleting environment variable: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error deleting environment variable: %v", err)
+	}
+	return n > 0, nil
+}
+
+// DeleteAll removes every variable of a deployment
+func (s *Store) DeleteAll(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM deployment_env WHERE deployment_name = ?", name); err != nil {
+		return fmt.Errorf("error deleting environment variables: %v", err)
+	}
+	return nil
+}
+
+// Redact returns a copy of vars with secret values removed
+func Redact(vars []types.EnvVar) []types.EnvVar {
+	redacted := make([]types.EnvVar, len(vars))
+	for i, v := range vars {
+		if v.Secret {
+			v.Value = ""
+		}
+		redacted[i] = v
+	}
+	return redacted
+}
+
+// Encrypt seals a secret with the secrets key for storage. It returns
+// ErrNoKey if no key is configured.
+func (s *Store) Encrypt(plaintext string) (string, error) {
+	if s.aead == nil {
+		return "", ErrNoKey
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %v", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a secret sealed by Encrypt
+func (s *Store) Decrypt(encoded string) (string, error) {
+	if s.aead == nil {
+		return "", ErrNoKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("error decrypting secret: malformed value")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting secret: %v", err)
+	}
+	return string(plaintext), nil
+}
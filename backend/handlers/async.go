@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"main/queue"
+	"main/tracing"
+	"main/types"
+)
+
+// maxInvocationBody is the largest request body an asynchronous invocation
+// accepts
+const maxInvocationBody = 1 << 20
+
+// StartQueue starts delivering asynchronous invocations, including the
+// events published to topics
+func (h *Handlers) StartQueue() {
+	h.queue.Observe(h.recordAttempt)
+	h.queue.Start(h.deliverInvocation, h.config.Queue.Workers)
+}
+
+// deliverInvocation makes one attempt to deliver an asynchronous
+// invocation
+func (h *Handlers) deliverInvocation(ctx context.Context, inv queue.Invocation) (*http.Response, error) {
+	header := inv.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("X-Invocation-ID", inv.ID)
+	header.Set("X-Invocation-Attempt", strconv.Itoa(inv.Attempts))
+	return h.callFunction(ctx, inv.Deployment, inv.Method, inv.Path, header, inv.Body)
+}
+
+// invocationResponse is an invocation with its body
+type invocationResponse struct {
+	*queue.Invocation
+	Body string `json:"body"`
+}
+
+// invokeAsyncHandler serves POST /invoke-async/{name}/{path...}. The
+// request is stored and delivered to the function in the background; the
+// response points to the invocation's status.
+func (h *Handlers) invokeAsyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/invoke-async/")
+	name, path, _ := strings.Cut(rest, "/")
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	deployment, err := h.store.Get(r.Context(), name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+
+	body, ok := readBody(w, r, maxInvocationBody)
+	if !ok {
+		return
+	}
+	header := r.Header.Clone()
+	header.Del("Content-Length")
+	// Deliveries continue the trace of this request
+	tracing.Inject(r.Context(), header)
+	path = "/" + path
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+
+	inv, err := h.queue.Enqueue(r.Context(), queue.Invocation{
+		Deployment: name,
+		Method:     r.Method,
+		Path:       path,
+		Headers:    header,
+		Body:       body,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error queueing invocation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/invocations/"+inv.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// invocationsHandler serves GET /invocations/{id}, an asynchronous
+// invocation with its body, whether queued, delivered or a dead letter
+func (h *Handlers) invocationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/invocations/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	inv, err := h.queue.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving invocation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if inv == nil {
+		http.Error(w, "Invocation not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invocationResponse{Invocation: inv, Body: string(inv.Body)})
+}
+
+// deploymentInvocationsHandler serves /deployments/{name}/invocations, the
+// deployment's queued and most recently delivered asynchronous
+// invocations, newest first. ?limit= defaults to 50.
+func (h *Handlers) deploymentInvocationsHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if arg != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	list, err := h.queue.List(r.Context(), deployment.Name, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving invocations: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// deadLettersHandler serves the invocations that used up their attempts:
+//
+//	GET        /dead-letters[?deployment=]
+//	GET|DELETE /dead-letters/{id}
+//	POST       /dead-letters/{id}/requeue
+func (h *Handlers) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/dead-letters"), "/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		limit, ok := limitParam(w, r)
+		if !ok {
+			return
+		}
+		list, err := h.queue.DeadLetters(r.Context(), r.URL.Query().Get("deployment"), limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving dead letters: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case id == "":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	case action == "" && r.Method == http.MethodGet:
+		inv, err := h.queue.DeadLetter(r.Context(), id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving dead letter: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if inv == nil {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invocationResponse{Invocation: inv, Body: string(inv.Body)})
+
+	case action == "" && r.Method == http.MethodDelete:
+		found, err := h.queue.DeleteDeadLetter(r.Context(), id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error deleting dead letter: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "requeue" && r.Method == http.MethodPost:
+		inv, err := h.queue.Requeue(r.Context(), id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error requeueing dead letter: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if inv == nil {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/invocations/"+inv.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(inv)
+
+	case action == "" || action == "requeue":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"main/auth"
+)
+
+func (h *Handlers) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, expiresAt, err := h.auth.Login(req.Username, req.Password)
+	if err == auth.ErrInvalidCredentials {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error logging in: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     token,
+		"username":  req.Username,
+		"expiresAt": expiresAt.Format(time.RFC3339),
+	})
+}
+
+func (h *Handlers) meHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.UserFromContext(r.Context()))
+}
+
+// tokensHandler lists and creates API tokens at /auth/tokens and revokes
+// them at /auth/tokens/{id}
+func (h *Handlers) tokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	id := strings.TrimPrefix(r.URL.Path, "/auth/tokens")
+	id = strings.Trim(id, "/")
+	store := h.auth.Store()
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		tokens, err := store.ListAPITokens(user.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving tokens: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+
+	case id == "" && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		token, meta, err := store.CreateAPIToken(user.ID, req.Name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":    token,
+			"apiToken": meta,
+		})
+
+	case id != "" && r.Method == http.MethodDelete:
+		deleted, err := store.DeleteAPIToken(user.ID, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error deleting token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Token %s revoked", id)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"main/envvars"
+	"main/types"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envHandler serves /deployments/{name}/env and /deployments/{name}/env/{key}.
+// Secret values are never returned.
+func (h *Handlers) envHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, key string) {
+	name := deployment.Name
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		vars, err := h.envVars.List(r.Context(), name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving environment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(envvars.Redact(vars))
+
+	case key != "" && r.Method == http.MethodGet:
+		v, err := h.envVars.Get(r.Context(), name, key)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving environment variable: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if v == nil {
+			http.Error(w, "Environment variable not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(envvars.Redact([]types.EnvVar{*v})[0])
+
+	case key != "" && r.Method == http.MethodPut:
+		if !envKeyPattern.MatchString(key) {
+			http.Error(w, "Invalid environment variable name", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Value  string `json:"value"`
+			Secret bool   `json:"secret"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err := h.envVars.Set(r.Context(), name, types.EnvVar{Key: key, Value: req.Value, Secret: req.Secret})
+		if err == envvars.ErrNoKey {
+			http.Error(w, "Secrets are disabled: no secrets key configured", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error saving environment variable: %v", err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Environment variable %s saved. Rebuild or restart the function to apply it.", key)
+
+	case key != "" && r.Method == http.MethodDelete:
+		deleted, err := h.envVars.Delete(r.Context(), name, key)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error deleting environment variable: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Environment variable not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Environment variable %s deleted.", key)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -1,54 +1,139 @@
 package handlers
 
 import (
-	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"main/auth"
 	"main/config"
 	"main/db"
+	"main/envvars"
+	"main/jobs"
+	"main/logs"
+	"main/metrics"
+	"main/ops"
+	"main/ports"
+	"main/queue"
+	"main/revisions"
+	"main/runtime"
+	"main/schedules"
+	"main/state"
+	"main/topics"
 	"main/types"
+	"main/webhooks"
 
-	"github.com/creack/pty"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 type Handlers struct {
 	config      *config.Config
-	db          *sql.DB
+	store       db.DeploymentStore
+	states      *state.Machine
+	ops         *ops.Coordinator
 	upgrader    websocket.Upgrader
-	clients     map[*websocket.Conn]bool
+	clients     map[*wsClient]bool
 	clientsMux  sync.Mutex
-	runningCmds map[string]*exec.Cmd
+	runtime     runtime.FunctionRuntime
+	auth        *auth.Authenticator
+	envVars     *envvars.Store
+	logs        *logs.Store
+	revisions   *revisions.Store
+	jobs        *jobs.Manager
+	ports       *ports.Allocator
+	schedules   *schedules.Scheduler
+	webhooks    *webhooks.Store
+	queue       *queue.Queue
+	topics      *topics.Store
+	metrics     *metrics.Metrics
+	runningCmds map[string]*runtime.Process
+	startups    map[string]*startup
 	cmdMux      sync.Mutex
+	activity    map[string]*activity
+	activityMux sync.Mutex
+	// supervisors are guarded by supervisorMux
+	supervisors   map[string]*supervisor
+	supervisorMux sync.Mutex
+	// closing is closed when the server shuts down, ending log streams
+	closing   chan struct{}
+	closeOnce sync.Once
 }
 
-func NewHandlers(cfg *config.Config, db *sql.DB) *Handlers {
-	return &Handlers{
-		config: cfg,
-		db:     db,
+func NewHandlers(cfg *config.Config, store db.DeploymentStore, rt runtime.FunctionRuntime, authn *auth.Authenticator, envVars *envvars.Store, logStore *logs.Store, revStore *revisions.Store, jobManager *jobs.Manager, portAllocator *ports.Allocator, scheduler *schedules.Scheduler, webhookStore *webhooks.Store, invocationQueue *queue.Queue, topicStore *topics.Store, m *metrics.Metrics) *Handlers {
+	h := &Handlers{
+		config:    cfg,
+		store:     store,
+		states:    state.NewMachine(store),
+		ops:       ops.NewCoordinator(),
+		runtime:   rt,
+		auth:      authn,
+		envVars:   envVars,
+		logs:      logStore,
+		revisions: revStore,
+		jobs:      jobManager,
+		ports:     portAllocator,
+		schedules: scheduler,
+		webhooks:  webhookStore,
+		queue:     invocationQueue,
+		topics:    topicStore,
+		metrics:   m,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		},
-		clients:     make(map[*websocket.Conn]bool),
-		runningCmds: make(map[string]*exec.Cmd),
-	}
+		clients:     make(map[*wsClient]bool),
+		runningCmds: make(map[string]*runtime.Process),
+		startups:    make(map[string]*startup),
+		activity:    make(map[string]*activity),
+		supervisors: make(map[string]*supervisor),
+		closing:     make(chan struct{}),
+	}
+	m.Gauge("running_functions", "Function processes currently running.", func() float64 {
+		h.cmdMux.Lock()
+		defer h.cmdMux.Unlock()
+		return float64(len(h.runningCmds))
+	})
+	m.Gauge("websocket_clients", "Connected WebSocket clients.", func() float64 {
+		h.clientsMux.Lock()
+		defer h.clientsMux.Unlock()
+		return float64(len(h.clients))
+	})
+	go h.forwardLogs()
+	return h
+}
+
+// function describes a deployment, including its environment, to the
+// function runtime
+func (h *Handlers) function(ctx context.Context, d *types.Deployment) (runtime.Function, error) {
+	env, err := h.envVars.Environ(ctx, d.Name)
+	if err != nil {
+		return runtime.Function{}, err
+	}
+	return runtime.Function{
+		Name:     d.Name,
+		Language: d.Language,
+		Dir:      filepath.Join(h.config.Function.DataDir, d.Name),
+		Env:      env,
+	}, nil
 }
 
+// namePattern restricts deployment names to DNS labels, which also keeps
+// them from clashing with the backend's own directories under DataDir
+var namePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
 func (h *Handlers) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/ws", h.wsHandler)
 	mux.HandleFunc("/create/", h.createHandler)
@@ -58,56 +143,50 @@ func (h *Handlers) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/stop/", h.stopHandler)
 	mux.HandleFunc("/deployments/", h.handleDeployments)
 	mux.HandleFunc("/delete/", h.deleteHandler)
+	mux.HandleFunc("/invoke/", h.invokeHandler)
+	mux.HandleFunc("/invoke-async/", h.invokeAsyncHandler)
+	mux.HandleFunc("/invocations/", h.invocationsHandler)
+	mux.HandleFunc("/dead-letters", h.deadLettersHandler)
+	mux.HandleFunc("/dead-letters/", h.deadLettersHandler)
+	mux.HandleFunc("/topics", h.topicsHandler)
+	mux.HandleFunc("/topics/", h.topicsHandler)
+	mux.HandleFunc("/jobs/", h.jobsHandler)
+	mux.HandleFunc("/ports", h.portsHandler)
+	mux.HandleFunc("/config", h.configHandler)
+	mux.Handle("/metrics", h.metrics.Handler())
+	mux.HandleFunc("/hooks/", h.hooksHandler)
+	mux.HandleFunc("/auth/login", h.loginHandler)
+	mux.HandleFunc("/auth/me", h.meHandler)
+	mux.HandleFunc("/auth/tokens", h.tokensHandler)
+	mux.HandleFunc("/auth/tokens/", h.tokensHandler)
 }
 
-func (h *Handlers) broadcastMessage(message interface{}) {
-	h.clientsMux.Lock()
-	defer h.clientsMux.Unlock()
+// PublicPaths are the routes that can be called without authentication
+var PublicPaths = []string{"/auth/login", "/hooks/"}
 
-	msg, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
-		return
-	}
-
-	for client := range h.clients {
-		err := client.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Printf("Error sending message to client: %v", err)
-			client.Close()
-			delete(h.clients, client)
-		}
-	}
+// deploymentRoutes are the routes whose path starts with /{route}/{name}
+var deploymentRoutes = []string{
+	"/upload/", "/build/", "/start/", "/stop/", "/delete/",
+	"/deployments/", "/invoke/", "/invoke-async/",
 }
 
-func (h *Handlers) wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Error upgrading connection: %v", err)
-		return
-	}
-	defer conn.Close()
-
-	h.clientsMux.Lock()
-	h.clients[conn] = true
-	h.clientsMux.Unlock()
-
-	defer func() {
-		h.clientsMux.Lock()
-		delete(h.clients, conn)
-		h.clientsMux.Unlock()
-	}()
-
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			break
+// DeploymentName returns the name of the deployment a request is about, or
+// "" if it isn't about one
+func DeploymentName(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/create/") {
+		// Read the same way as by createHandler, which may be from the body
+		return r.FormValue("name")
+	}
+	for _, route := range deploymentRoutes {
+		if rest, ok := strings.CutPrefix(r.URL.Path, route); ok {
+			name, _, _ := strings.Cut(rest, "/")
+			return name
 		}
 	}
+	return ""
 }
 
 func (h *Handlers) createHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received Request at:", r.URL.Path)
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 3 {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
@@ -123,9 +202,26 @@ func (h *Handlers) createHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Name is required", http.StatusBadRequest)
 		return
 	}
+	if !namePattern.MatchString(name) {
+		http.Error(w, "Name must consist of lowercase letters, digits and dashes", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := h.ops.Begin(r.Context(), name, ops.Create, false)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	// Released here unless handed over to the job below
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			lease.Release()
+		}
+	}()
 
 	// Check if deployment already exists
-	existingDeployment, err := db.GetDeployment(name)
+	existingDeployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error checking existing deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -139,7 +235,7 @@ func (h *Handlers) createHandler(w http.ResponseWriter, r *http.Request) {
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		err := os.Mkdir(dataDir, 0755)
 		if err != nil {
-			log.Printf("Error creating directory: %v", err)
+			slog.ErrorContext(r.Context(), "Error creating directory", "error", err)
 			http.Error(w, fmt.Sprintf("Error creating directory: %s", err), http.StatusInternalServerError)
 			return
 		}
@@ -153,39 +249,70 @@ func (h *Handlers) createHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Generate a new UUID for the deployment ID
 	deploymentID := uuid.New().String()
-	deployment := types.Deployment{
+	deployment := &types.Deployment{
 		ID:        deploymentID,
 		Name:      name,
 		Language:  language,
-		Status:    "Creating",
 		CreatedAt: time.Now().Format(time.RFC3339),
 	}
+	if err := h.states.Create(r.Context(), deployment, "create requested"); err != nil {
+		http.Error(w, fmt.Sprintf("Error saving deployment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
+		"type": "create_deployment",
+		"data": deployment,
+	})
 
-	cmd := exec.Command("func", "create", "-l", language, name)
-	cmd.Dir = dataDir
-	output, err := cmd.CombinedOutput()
-	log.Printf("Command Output: %s", output)
-
+	job, err := h.jobs.Run(r.Context(), name, string(ops.Create), false, func(ctx context.Context, run *jobs.Run) error {
+		defer lease.Release()
+		started := time.Now()
+		err := h.createFunction(ctx, deployment, run.Output)
+		h.metrics.ObserveOperation("create", deployment.Language, metrics.Outcome(err, ctx.Err() != nil), started)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error executing command: %v", err)
-		http.Error(w, fmt.Sprintf("Error creating function: %s\nOutput: %s", err, output), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error starting job: %v", err), http.StatusInternalServerError)
 		return
 	}
+	handedOver = true
+	writeJob(w, job)
+}
+
+// createFunction scaffolds a deployment in the Creating status with the
+// function runtime, copying the runtime's output to out
+func (h *Handlers) createFunction(ctx context.Context, deployment *types.Deployment, out io.Writer) error {
+	fn, err := h.function(ctx, deployment)
+	if err == nil {
+		var output []byte
+		output, err = h.runtime.Create(ctx, fn)
+		out.Write(output)
+		slog.DebugContext(ctx, "Create output", "deployment", deployment.Name, "output", string(output))
+	}
+	// The result is recorded even if the create was cancelled
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating function", "deployment", deployment.Name, "error", err)
+		h.setFailed(ctx, deployment, fmt.Sprintf("create failed: %v", err))
+		return err
+	}
 
 	// Update status to Stopped after creation
-	deployment.Status = "Stopped"
-	if err := db.CreateDeployment(deployment); err != nil {
-		http.Error(w, fmt.Sprintf("Error saving deployment: %v", err), http.StatusInternalServerError)
-		return
+	if err := h.states.Transition(ctx, deployment, state.Stopped, "function created"); err != nil {
+		return err
+	}
+
+	// Record the generated template as the first revision
+	if _, err := h.snapshot(ctx, deployment, 0); err != nil {
+		slog.ErrorContext(ctx, "Error recording revision", "deployment", deployment.Name, "error", err)
 	}
 
 	// Broadcast final status
-	h.broadcastMessage(map[string]interface{}{
-		"type": "create_deployment",
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
+		"type": "status_update",
 		"data": deployment,
 	})
-
-	fmt.Fprintf(w, "Function created successfully: %s", output)
+	return nil
 }
 
 func (h *Handlers) uploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -218,7 +345,14 @@ func (h *Handlers) uploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer packageFile.Close()
 
-	deployment, err := db.GetDeployment(name)
+	lease, err := h.beginOperation(r, name, ops.Upload)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	defer lease.Release()
+
+	deployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -237,16 +371,21 @@ func (h *Handlers) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error saving package file", http.StatusInternalServerError)
 		return
 	}
+	rev, err := h.snapshot(r.Context(), deployment, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error recording revision: %v", err), http.StatusInternalServerError)
+		return
+	}
 	deployment.Built = false
-	if err := db.UpdateDeployment(*deployment); err != nil {
+	if err := h.store.Update(r.Context(), *deployment); err != nil {
 		http.Error(w, fmt.Sprintf("Error updating deployment status: %v", err), http.StatusInternalServerError)
 		return
 	}
-	h.broadcastMessage(map[string]interface{}{
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
 		"type": "status_update",
 		"data": deployment,
 	})
-	fmt.Fprintf(w, "Files uploaded successfully")
+	fmt.Fprintf(w, "Files uploaded successfully as revision %d", rev.Number)
 }
 
 func saveFile(file io.Reader, path string) error {
@@ -269,7 +408,7 @@ func (h *Handlers) buildHandler(w http.ResponseWriter, r *http.Request) {
 	name = strings.TrimSuffix(name, "/")
 
 	// Find the deployment
-	deployment, err := db.GetDeployment(name)
+	deployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -278,57 +417,26 @@ func (h *Handlers) buildHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Deployment not found", http.StatusNotFound)
 		return
 	}
-
-	// Set status to "Building"
-	deployment.Status = "Building"
-	if err := db.UpdateDeployment(*deployment); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating deployment status: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// Broadcast building status
-	h.broadcastMessage(map[string]interface{}{
-		"type": "status_update",
-		"data": deployment,
-	})
-
-	// Run build and deploy in a separate goroutine to avoid blocking the request
-	go func(d *types.Deployment, fnName string) {
-		// Step 1: Build
-		buildCmd := exec.Command("func", "build", fnName, "--registry", h.config.Registry.Address)
-		buildCmd.Dir = filepath.Join(h.config.Function.DataDir, fnName)
-		buildOutput, err := buildCmd.CombinedOutput()
-		if err != nil {
-			log.Printf("[ERROR] Build for %s failed: %v\nOutput:\n%s", fnName, err, string(buildOutput))
-			d.Status = "Failed"
-			if err := db.UpdateDeployment(*d); err != nil {
-				log.Printf("Error updating deployment status: %v", err)
-			}
-			// Broadcast status update
-			h.broadcastMessage(map[string]interface{}{
-				"type": "status_update",
-				"data": d,
-			})
+	if !queued(r) {
+		if err := state.Check(deployment, state.Building); err != nil {
+			writeStatusError(w, err)
 			return
 		}
-		log.Printf("[INFO] Build output for %s:\n%s", fnName, string(buildOutput))
+	}
 
-		// Update status to "Built" after successful build
-		d.Status = "Stopped"
-		d.Built = true
-		if err := db.UpdateDeployment(*d); err != nil {
-			log.Printf("Error updating deployment status: %v", err)
+	job, err := h.startJob(r, name, ops.Build, func(ctx context.Context, out io.Writer) error {
+		// Queued builds see the deployment as left by earlier operations
+		deployment, err := h.store.Get(ctx, name)
+		if err != nil || deployment == nil {
+			return fmt.Errorf("deployment not found: %v", err)
 		}
-		// Broadcast status update
-		h.broadcastMessage(map[string]interface{}{
-			"type": "build_complete",
-			"data": d,
-		})
-	}(deployment, name)
-
-	// Immediately return a quick message
-	w.WriteHeader(http.StatusAccepted)
-	fmt.Fprintf(w, "Build process started for '%s'. Status set to Building.\n", name)
+		return h.buildFunction(ctx, deployment, "build requested", out)
+	})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	writeJob(w, job)
 }
 
 func (h *Handlers) startHandler(w http.ResponseWriter, r *http.Request) {
@@ -336,7 +444,7 @@ func (h *Handlers) startHandler(w http.ResponseWriter, r *http.Request) {
 	name = strings.TrimSuffix(name, "/")
 
 	// Find the deployment
-	deployment, err := db.GetDeployment(name)
+	deployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -346,159 +454,50 @@ func (h *Handlers) startHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If it's already running, return early
-	if deployment.Status == "Running" {
-		http.Error(w, "Function is already started", http.StatusBadRequest)
-		return
-	}
-
-	// Check if the function is built
-	if !deployment.Built {
-		http.Error(w, "Function needs to be built first", http.StatusBadRequest)
-		return
-	}
-
-	// Update status to Starting
-	deployment.Status = "Starting"
-	if err := db.UpdateDeployment(*deployment); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating deployment status: %v", err), http.StatusInternalServerError)
-		return
+	// Queued starts may follow a build, so they are checked in the job
+	if !queued(r) {
+		// Check if the function is built
+		if !deployment.Built {
+			http.Error(w, "Function needs to be built first", http.StatusBadRequest)
+			return
+		}
+		if err := state.Check(deployment, state.Starting); err != nil {
+			writeStatusError(w, err)
+			return
+		}
 	}
 
-	// Broadcast starting status
-	h.broadcastMessage(map[string]interface{}{
-		"type": "status_update",
-		"data": deployment,
+	// The job lasts until the function is running or has failed
+	job, err := h.startJob(r, name, ops.Start, func(ctx context.Context, out io.Writer) error {
+		deployment, err := h.store.Get(ctx, name)
+		if err != nil || deployment == nil {
+			return fmt.Errorf("deployment not found: %v", err)
+		}
+		if !deployment.Built {
+			return errors.New("function needs to be built first")
+		}
+		return h.runFunction(ctx, deployment, "start requested", out)
 	})
-
-	// Prepare the command: e.g. func run <name>
-	cmd := exec.Command("func", "run", name, "--registry", h.config.Registry.Address)
-	cmd.Dir = filepath.Join(h.config.Function.DataDir, name)
-
-	// Create a pty
-	ptmx, err := pty.Start(cmd)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error starting function with pty: %v", err), http.StatusInternalServerError)
+		writeStatusError(w, err)
 		return
 	}
-	// defer ptmx.Close()
-
-	// Store the cmd in our runningCmds map so we can stop it later
-	h.cmdMux.Lock()
-	h.runningCmds[name] = cmd
-	h.cmdMux.Unlock()
-
-	// Return 200 immediately to prevent timeout
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Function %s is starting...", name)
-
-	// Start reading output in a goroutine
-	go func() {
-		buf := make([]byte, 4096)
-		startTime := time.Now()
-		timeout := 30 * time.Second
-		errorBuffer := bytes.NewBuffer(nil)
-		port := ""
-
-		for {
-			n, err := ptmx.Read(buf)
-			if n > 0 {
-				outputChunk := string(buf[:n])
-				log.Printf("[func run output for %s]: %s", name, outputChunk)
-				errorBuffer.Write(buf[:n])
-
-				// Try to extract port from the output
-				if port == "" {
-					patterns := []string{
-						`Running on host port (\d+)`,
-						`port (\d+)`,
-						`listening on port (\d+)`,
-						`started on port (\d+)`,
-					}
-
-					for _, pattern := range patterns {
-						re := regexp.MustCompile(pattern)
-						matches := re.FindStringSubmatch(outputChunk)
-						if len(matches) > 1 {
-							port = matches[1]
-							log.Printf("[DEBUG] Found port using pattern '%s': %s", pattern, port)
-							h.cmdMux.Lock()
-							deployment.Port = port
-							deployment.Status = "Running"
-							if err := db.UpdateDeployment(*deployment); err != nil {
-								log.Printf("Error updating deployment port: %v", err)
-							}
-							h.cmdMux.Unlock()
-							// Broadcast status update with port
-							h.broadcastMessage(map[string]interface{}{
-								"type": "status_update",
-								"data": deployment,
-							})
-							break
-						}
-					}
-				}
-			}
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("[%s run] read error: %v", name, err)
-				}
-				break
-			}
-
-			// Check timeout
-			if time.Since(startTime) > timeout {
-				log.Printf("[%s] Function startup timeout after %v", name, timeout)
-				if port == "" {
-					log.Printf("[%s] Warning: No port detected within timeout period", name)
-					// Update status to indicate timeout
-					h.cmdMux.Lock()
-					deployment.Status = "Failed"
-					if err := db.UpdateDeployment(*deployment); err != nil {
-						log.Printf("Error updating deployment status: %v", err)
-					}
-					h.cmdMux.Unlock()
-					// Broadcast failed status
-					h.broadcastMessage(map[string]interface{}{
-						"type": "status_update",
-						"data": deployment,
-					})
-					// Kill the process if it's still running
-					if cmd.Process != nil {
-						cmd.Process.Kill()
-					}
-					h.cmdMux.Lock()
-					delete(h.runningCmds, name)
-					h.cmdMux.Unlock()
-				}
-				return
-			}
-		}
-
-		// If we get here, the process has exited
-		if port == "" {
-			log.Printf("[%s] Function exited without detecting port. Error output: %s", name, errorBuffer.String())
-			h.cmdMux.Lock()
-			deployment.Status = "Failed"
-			if err := db.UpdateDeployment(*deployment); err != nil {
-				log.Printf("Error updating deployment status: %v", err)
-			}
-			h.cmdMux.Unlock()
-			// Broadcast failed status
-			h.broadcastMessage(map[string]interface{}{
-				"type": "status_update",
-				"data": deployment,
-			})
-		}
-	}()
+	writeJob(w, job)
 }
 
 func (h *Handlers) stopHandler(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimPrefix(r.URL.Path, "/stop/")
 	name = strings.TrimSuffix(name, "/")
 
+	lease, err := h.beginOperation(r, name, ops.Stop)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	defer lease.Release()
+
 	// Find the deployment
-	deployment, err := db.GetDeployment(name)
+	deployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -508,98 +507,63 @@ func (h *Handlers) stopHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the function is running
-	if deployment.Status != "Running" {
-		http.Error(w, "Function is not running", http.StatusBadRequest)
+	if err := h.stopFunction(r.Context(), deployment, "stop requested"); err != nil {
+		writeStatusError(w, err)
 		return
 	}
 
-	h.cmdMux.Lock()
-	cmd, exists := h.runningCmds[name]
-	h.cmdMux.Unlock()
+	fmt.Fprintf(w, "Function %s stopped.", name)
+}
 
-	if !exists {
-		// If command not found but status is Running, update status to Stopped
-		deployment.Status = "Stopped"
-		deployment.Port = ""
-		if err := db.UpdateDeployment(*deployment); err != nil {
-			http.Error(w, fmt.Sprintf("Error updating deployment status: %v", err), http.StatusInternalServerError)
-			return
-		}
-		// Broadcast status update
-		h.broadcastMessage(map[string]interface{}{
-			"type": "status_update",
-			"data": deployment,
-		})
-		fmt.Fprintf(w, "Function %s stopped.", name)
+func (h *Handlers) handleDeployments(w http.ResponseWriter, r *http.Request) {
+	// If exact path is "/deployments/", list all deployments.
+	if r.URL.Path == "/deployments/" || r.URL.Path == "/deployments" {
+		h.deploymentsHandler(w, r)
 		return
 	}
 
-	// Send SIGINT (Ctrl+C) to the process and its children
-	if cmd.Process != nil {
-		// First try to send SIGINT to the process group
-		if err := exec.Command("pkill", "-INT", "-P", fmt.Sprintf("%d", cmd.Process.Pid)).Run(); err != nil {
-			log.Printf("Error sending SIGINT to process group: %v", err)
-		}
-		// Then send SIGINT to the main process
-		if err := cmd.Process.Signal(os.Interrupt); err != nil {
-			log.Printf("Error sending SIGINT to process: %v", err)
-		}
+	// Otherwise the path is /deployments/{name}[/{resource}[/{arg}]]
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/")
+	name, sub, _ := strings.Cut(rest, "/")
+	resource, arg, _ := strings.Cut(sub, "/")
 
-		// Wait for the process to exit with a timeout
-		done := make(chan error, 1)
-		go func() {
-			done <- cmd.Wait()
-		}()
-
-		select {
-		case err := <-done:
-			if err != nil && err.Error() != "signal: interrupt" {
-				log.Printf("Error waiting for process: %v", err)
-			}
-		case <-time.After(10 * time.Second):
-			// If process doesn't exit within 10 seconds, force kill it
-			log.Printf("Process did not exit after SIGINT, forcing kill")
-			if err := cmd.Process.Kill(); err != nil {
-				log.Printf("Error killing process: %v", err)
-			}
-		}
+	subHandlers := map[string]func(http.ResponseWriter, *http.Request, *types.Deployment, string){
+		"env":           h.envHandler,
+		"health":        h.healthHandler,
+		"history":       h.historyHandler,
+		"invocations":   h.deploymentInvocationsHandler,
+		"jobs":          h.deploymentJobsHandler,
+		"logs":          h.logsHandler,
+		"operations":    h.operationsHandler,
+		"revisions":     h.revisionsHandler,
+		"schedules":     h.schedulesHandler,
+		"subscriptions": h.deploymentSubscriptionsHandler,
+		"webhooks":      h.webhooksHandler,
 	}
-
-	// Clean up
-	h.cmdMux.Lock()
-	delete(h.runningCmds, name)
-	h.cmdMux.Unlock()
-
-	// Update status
-	deployment.Status = "Stopped"
-	deployment.Port = ""
-	if err := db.UpdateDeployment(*deployment); err != nil {
-		http.Error(w, fmt.Sprintf("Error updating deployment status: %v", err), http.StatusInternalServerError)
+	if resource == "" {
+		h.deploymentDetailHandler(w, r)
+		return
+	}
+	handler, ok := subHandlers[resource]
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
 
-	// Broadcast status update
-	h.broadcastMessage(map[string]interface{}{
-		"type": "status_update",
-		"data": deployment,
-	})
-
-	fmt.Fprintf(w, "Function %s stopped.", name)
-}
-
-func (h *Handlers) handleDeployments(w http.ResponseWriter, r *http.Request) {
-	// If exact path is "/deployments/", list all deployments.
-	if r.URL.Path == "/deployments/" || r.URL.Path == "/deployments" {
-		h.deploymentsHandler(w, r)
+	deployment, err := h.store.Get(r.Context(), name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
 		return
 	}
-	// Otherwise, treat it as a request for a named deployment's details.
-	h.deploymentDetailHandler(w, r)
+	handler(w, r, deployment, arg)
 }
 
 func (h *Handlers) deploymentsHandler(w http.ResponseWriter, r *http.Request) {
-	deployments, err := db.GetAllDeployments()
+	deployments, err := h.store.List(r.Context())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployments: %v", err), http.StatusInternalServerError)
 		return
@@ -613,7 +577,7 @@ func (h *Handlers) deploymentDetailHandler(w http.ResponseWriter, r *http.Reques
 	name := strings.TrimPrefix(r.URL.Path, "/deployments/")
 	name = strings.TrimSuffix(name, "/")
 
-	deployment, err := db.GetDeployment(name)
+	deployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -631,15 +595,26 @@ func (h *Handlers) deploymentDetailHandler(w http.ResponseWriter, r *http.Reques
 	codeContent, _ := os.ReadFile(codePath)
 	pkgContent, _ := os.ReadFile(pkgPath)
 
+	coldStarts, err := h.store.ColdStarts(r.Context(), name, 10)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error retrieving cold starts", "error", err)
+	}
+	env, err := h.envVars.List(r.Context(), name)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error retrieving environment", "error", err)
+	}
+
 	detail := types.DeploymentDetail{
 		Deployment: *deployment,
 		Code:       string(codeContent),
 		Package:    string(pkgContent),
+		ColdStarts: coldStarts,
+		Env:        envvars.Redact(env),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(detail); err != nil {
-		log.Printf("Error encoding JSON: %v", err)
+		slog.ErrorContext(r.Context(), "Error encoding JSON", "error", err)
 	}
 }
 
@@ -663,8 +638,15 @@ func (h *Handlers) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimPrefix(r.URL.Path, "/delete/")
 	name = strings.TrimSuffix(name, "/")
 
+	lease, err := h.beginOperation(r, name, ops.Delete)
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	defer lease.Release()
+
 	// Find the deployment
-	deployment, err := db.GetDeployment(name)
+	deployment, err := h.store.Get(r.Context(), name)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
 		return
@@ -675,35 +657,64 @@ func (h *Handlers) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If the function is running, stop it first
-	if deployment.Status == "Running" {
+	if state.Up(deployment) {
+		h.unsupervise(name)
 		h.cmdMux.Lock()
-		cmd, exists := h.runningCmds[name]
+		proc, exists := h.runningCmds[name]
 		h.cmdMux.Unlock()
 
-		if exists && cmd.Process != nil {
-			if err := cmd.Process.Kill(); err != nil {
-				log.Printf("Error killing process: %v", err)
+		if exists {
+			if err := h.runtime.Stop(proc); err != nil {
+				slog.ErrorContext(r.Context(), "Error stopping function", "error", err)
 			}
 			h.cmdMux.Lock()
 			delete(h.runningCmds, name)
 			h.cmdMux.Unlock()
 		}
+		h.forget(name)
 	}
 
 	// Delete the deployment from the database
-	if err := db.DeleteDeployment(name); err != nil {
+	if err := h.store.Delete(r.Context(), name); err != nil {
 		http.Error(w, fmt.Sprintf("Error deleting deployment: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if err := h.envVars.DeleteAll(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting environment", "error", err)
+	}
+	if err := h.logs.Delete(name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting logs", "error", err)
+	}
+	if err := h.revisions.Delete(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting revisions", "error", err)
+	}
+	if err := h.jobs.DeleteAll(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting jobs", "error", err)
+	}
+	if err := h.ports.Release(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error releasing port", "error", err)
+	}
+	if err := h.schedules.DeleteAll(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting schedules", "error", err)
+	}
+	if err := h.webhooks.DeleteAll(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting webhooks", "error", err)
+	}
+	if err := h.queue.DeleteAll(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting invocations", "error", err)
+	}
+	if err := h.topics.DeleteAll(r.Context(), name); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting subscriptions", "error", err)
+	}
 
 	// Delete the function directory
 	functionDir := filepath.Join(h.config.Function.DataDir, name)
 	if err := os.RemoveAll(functionDir); err != nil {
-		log.Printf("Error deleting function directory: %v", err)
+		slog.ErrorContext(r.Context(), "Error deleting function directory", "error", err)
 	}
 
 	// Broadcast deletion
-	h.broadcastMessage(map[string]interface{}{
+	h.broadcastMessage(name, map[string]interface{}{
 		"type": "deployment_deleted",
 		"data": map[string]string{
 			"name": name,
@@ -713,3 +724,102 @@ func (h *Handlers) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Deployment %s deleted successfully", name)
 }
+
+// writeStatusError responds with 409 Conflict when an operation is not
+// allowed in the deployment's current status or while another operation is
+// in progress, and with 503 Service Unavailable while the backend shuts
+// down
+func writeStatusError(w http.ResponseWriter, err error) {
+	if errors.Is(err, state.ErrIllegalTransition) || errors.Is(err, ops.ErrBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, jobs.ErrShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Error updating deployment status: %v", err), http.StatusInternalServerError)
+}
+
+// limitParam parses ?limit=, which defaults to 50. It responds 400 Bad
+// Request and returns false if the limit is invalid.
+func limitParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return 0, false
+		}
+		limit = n
+	}
+	return limit, true
+}
+
+// readBody reads a request body of at most max bytes. It responds 413
+// Request Entity Too Large if the body is longer, 400 Bad Request if it
+// can't be read, and returns false.
+func readBody(w http.ResponseWriter, r *http.Request, max int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, fmt.Sprintf("Error reading request body: %v", err), code)
+		return nil, false
+	}
+	return body, true
+}
+
+// historyHandler serves /deployments/{name}/history, the deployment's most
+// recent status transitions, newest first. ?limit= defaults to 50.
+func (h *Handlers) historyHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if arg != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.states.History(r.Context(), deployment.Name, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving status history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// beginOperation claims a deployment for a lifecycle operation. With
+// ?queue=true the request waits for operations already in progress instead
+// of failing with ops.ErrBusy.
+func (h *Handlers) beginOperation(r *http.Request, name string, kind ops.Kind) (*ops.Lease, error) {
+	return h.ops.Begin(r.Context(), name, kind, queued(r))
+}
+
+// operationsHandler serves /deployments/{name}/operations, the operation in
+// progress and those queued behind it
+func (h *Handlers) operationsHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if arg != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	current, pending := h.ops.Operations(deployment.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"current": current,
+		"pending": pending,
+	})
+}
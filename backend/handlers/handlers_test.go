@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"main/auth"
+	"main/config"
+	"main/db"
+	"main/envvars"
+	"main/jobs"
+	"main/logs"
+	"main/metrics"
+	"main/ports"
+	"main/queue"
+	"main/revisions"
+	"main/runtime"
+	"main/schedules"
+	"main/state"
+	"main/topics"
+	"main/webhooks"
+)
+
+// testServer is a Handlers backed by the in-memory deployment store and the
+// native runtime, serving its routes without authentication
+type testServer struct {
+	t     *testing.T
+	h     *Handlers
+	store *db.MemoryStore
+	mux   *http.ServeMux
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Function.Runtime = "native"
+	cfg.Function.DataDir = dir
+
+	// Stores other than the deployments' still need a database
+	conn, err := db.InitDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authn := auth.NewAuthenticator(auth.NewStore(conn), []byte("test secret"), time.Hour)
+	envVars, err := envvars.NewStore(conn, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logStore, err := logs.NewStore(filepath.Join(dir, ".logs"), cfg.Logs.MaxAttempts, cfg.Logs.MaxFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revStore, err := revisions.NewStore(conn, filepath.Join(dir, ".revisions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobManager, err := jobs.NewManager(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portAllocator, err := ports.NewAllocator(conn, cfg.Ports.Min, cfg.Ports.Max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invocationQueue, err := queue.NewQueue(conn, cfg.Queue.MaxAttempts,
+		cfg.Queue.RetryBackoff, cfg.Queue.MaxRetryBackoff, cfg.Queue.Timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := db.NewMemoryStore()
+	h := NewHandlers(cfg, store, runtime.NewNative(cfg), authn, envVars, logStore, revStore, jobManager,
+		portAllocator, schedules.NewScheduler(conn, cfg.Schedules.Timeout), webhooks.NewStore(conn, envVars),
+		invocationQueue, topics.NewStore(conn), metrics.New())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		jobManager.Shutdown(ctx)
+		h.Shutdown(ctx)
+		conn.Close()
+	})
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return &testServer{t: t, h: h, store: store, mux: mux}
+}
+
+// do serves a request and returns the response
+func (s *testServer) do(method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect serves a request and fails the test unless it gets status code
+func (s *testServer) expect(method, path string, code int) *httptest.ResponseRecorder {
+	s.t.Helper()
+	rec := s.do(method, path)
+	if rec.Code != code {
+		s.t.Fatalf("%s %s: got %d %q, want %d", method, path, rec.Code, strings.TrimSpace(rec.Body.String()), code)
+	}
+	return rec
+}
+
+// runJob serves a request that starts a job and waits for the job to
+// succeed
+func (s *testServer) runJob(method, path string) {
+	s.t.Helper()
+	rec := s.expect(method, path, http.StatusAccepted)
+	var job jobs.Job
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		s.t.Fatalf("%s %s: error decoding job: %v", method, path, err)
+	}
+
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		if err := json.NewDecoder(s.expect(http.MethodGet, "/jobs/"+job.ID, http.StatusOK).Body).Decode(&job); err != nil {
+			s.t.Fatalf("error decoding job: %v", err)
+		}
+		switch job.Status {
+		case jobs.StatusSucceeded:
+			return
+		case jobs.StatusFailed, jobs.StatusCancelled:
+			s.t.Fatalf("%s %s: job %s: %s\n%s", method, path, job.Status, job.Error, job.Output)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.t.Fatalf("%s %s: job still %s after a minute", method, path, job.Status)
+}
+
+// expectStatus fails the test unless the deployment has status want
+func (s *testServer) expectStatus(name string, want state.Status) {
+	s.t.Helper()
+	d, err := s.store.Get(context.Background(), name)
+	if err != nil || d == nil {
+		s.t.Fatalf("deployment %s not found: %v", name, err)
+	}
+	if got := state.Of(d); got != want {
+		s.t.Fatalf("deployment %s is %s, want %s", name, got, want)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	s := newTestServer(t)
+
+	s.runJob(http.MethodPost, "/create/go?name=hello")
+	s.expectStatus("hello", state.Stopped)
+
+	s.runJob(http.MethodPost, "/build/hello")
+	s.expectStatus("hello", state.Stopped)
+
+	s.runJob(http.MethodPost, "/start/hello")
+	s.expectStatus("hello", state.Running)
+
+	rec := s.expect(http.MethodGet, "/invoke/hello/", http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "Hello from go") {
+		t.Fatalf("unexpected invocation response %q", rec.Body.String())
+	}
+
+	s.expect(http.MethodPost, "/stop/hello", http.StatusOK)
+	s.expectStatus("hello", state.Stopped)
+
+	s.expect(http.MethodDelete, "/delete/hello", http.StatusOK)
+	s.expect(http.MethodGet, "/deployments/hello", http.StatusNotFound)
+}
+
+func TestNotFound(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, r := range []struct{ method, path string }{
+		{http.MethodGet, "/deployments/missing"},
+		{http.MethodPost, "/build/missing"},
+		{http.MethodPost, "/start/missing"},
+		{http.MethodPost, "/stop/missing"},
+		{http.MethodDelete, "/delete/missing"},
+	} {
+		s.expect(r.method, r.path, http.StatusNotFound)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	s := newTestServer(t)
+	s.runJob(http.MethodPost, "/create/go?name=hello")
+
+	// A stopped function can't be stopped again
+	s.expect(http.MethodPost, "/stop/hello", http.StatusConflict)
+
+	s.runJob(http.MethodPost, "/build/hello")
+	s.runJob(http.MethodPost, "/start/hello")
+
+	// A running function can't be built or started again
+	s.expect(http.MethodPost, "/build/hello", http.StatusConflict)
+	s.expect(http.MethodPost, "/start/hello", http.StatusConflict)
+	s.expectStatus("hello", state.Running)
+}
+
+func TestDeploymentName(t *testing.T) {
+	form := func(target, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	for _, tt := range []struct {
+		r    *http.Request
+		want string
+	}{
+		{httptest.NewRequest(http.MethodPost, "/create/go?name=hello", nil), "hello"},
+		{form("/create/go", "name=hello"), "hello"},
+		{httptest.NewRequest(http.MethodPost, "/start/hello", nil), "hello"},
+		{httptest.NewRequest(http.MethodGet, "/invoke/hello/api/items?x=1", nil), "hello"},
+		{httptest.NewRequest(http.MethodGet, "/jobs", nil), ""},
+	} {
+		if got := DeploymentName(tt.r); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.r.Method, tt.r.URL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"main/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// invokeHandler proxies /invoke/{name}/{path...} to the running function,
+// giving every function a stable URL regardless of the port it runs on.
+// Stopped functions are cold started and the request is held until they
+// are listening.
+func (h *Handlers) invokeHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/invoke/")
+	name, path, _ := strings.Cut(rest, "/")
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	done := h.beginInvocation(name)
+	defer done()
+
+	ctx, span := startInvocation(r.Context(), name)
+	r = r.WithContext(ctx)
+	code := 0
+	defer func() { endInvocation(span, code) }()
+
+	started := time.Now()
+	deployment, err := h.ensureRunning(ctx, name)
+	if err == errNotBuilt {
+		h.metrics.ObserveInvocation(name, 0, started)
+		http.Error(w, "Function needs to be built first", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		h.metrics.ObserveInvocation(name, 0, started)
+		http.Error(w, fmt.Sprintf("Error starting function: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	if deployment == nil {
+		h.forget(name)
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+
+	proxy := h.newFunctionProxy(name, deployment.Port, "/"+path)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		code = resp.StatusCode
+		return nil
+	}
+	proxy.ServeHTTP(w, r)
+	h.metrics.ObserveInvocation(name, code, started)
+}
+
+// newFunctionProxy returns a reverse proxy that forwards the request method,
+// headers, body and query to path on the function listening on port
+func (h *Handlers) newFunctionProxy(name, port, path string) *httputil.ReverseProxy {
+	target := &url.URL{Scheme: "http", Host: "localhost:" + port}
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			req.Host = target.Host
+			tracing.Inject(req.Context(), req.Header)
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			slog.ErrorContext(req.Context(), "Error proxying invocation", "deployment", name, "error", err)
+			http.Error(w, fmt.Sprintf("Error invoking function: %v", err), http.StatusBadGateway)
+		},
+	}
+}
+
+// hopHeaders are not forwarded by callFunction
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// callFunction sends a request to path, which may include a query, on a
+// deployment's function, cold starting it if it is stopped. It is used for
+// invocations that don't come straight from a client request. Unless ctx is
+// traced, the invocation continues the trace in header, if any.
+func (h *Handlers) callFunction(ctx context.Context, name, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	done := h.beginInvocation(name)
+	defer done()
+
+	ctx, span := startInvocation(tracing.Extract(ctx, header), name)
+	code := 0
+	defer func() { endInvocation(span, code) }()
+
+	started := time.Now()
+	deployment, err := h.ensureRunning(ctx, name)
+	if err != nil {
+		h.metrics.ObserveInvocation(name, 0, started)
+		return nil, err
+	}
+	if deployment == nil {
+		h.forget(name)
+		return nil, fmt.Errorf("deployment %s not found", name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost:"+deployment.Port+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	for _, k := range hopHeaders {
+		req.Header.Del(k)
+	}
+	tracing.Inject(ctx, req.Header)
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		code = resp.StatusCode
+	}
+	h.metrics.ObserveInvocation(name, code, started)
+	return resp, err
+}
+
+// startInvocation starts a client span for invoking a function. Its trace
+// context is passed on to the function in the traceparent header.
+func startInvocation(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "invoke "+name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("deployment", name)))
+}
+
+// endInvocation ends an invocation span with the status code of the
+// function's response, or 0 if there was none
+func endInvocation(span trace.Span, code int) {
+	if code != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", code))
+	}
+	if code == 0 || code >= 500 {
+		span.SetStatus(codes.Error, "function did not respond successfully")
+	}
+	span.End()
+}
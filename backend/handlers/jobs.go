@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"main/jobs"
+	"main/ops"
+	"main/types"
+)
+
+// queued reports whether the request asked to wait for operations already
+// in progress with ?queue=true
+func queued(r *http.Request) bool {
+	queue := r.URL.Query().Get("queue")
+	return queue == "true" || queue == "1"
+}
+
+// startJob runs fn as a background job that holds the deployment for an
+// operation of the given kind. Unless the request is queued it fails with
+// ops.ErrBusy while another operation is in progress; a queued job waits
+// for the deployment instead.
+func (h *Handlers) startJob(r *http.Request, name string, kind ops.Kind, fn func(ctx context.Context, out io.Writer) error) (*jobs.Job, error) {
+	return h.runJob(r.Context(), name, kind, queued(r), fn)
+}
+
+// runJob is startJob for operations that don't come from a request, such
+// as automatic restarts
+func (h *Handlers) runJob(ctx context.Context, name string, kind ops.Kind, queue bool, fn func(ctx context.Context, out io.Writer) error) (*jobs.Job, error) {
+	var lease *ops.Lease
+	if !queue {
+		l, err := h.ops.Begin(ctx, name, kind, false)
+		if err != nil {
+			return nil, err
+		}
+		lease = l
+	}
+
+	job, err := h.jobs.Run(ctx, name, string(kind), queue, func(ctx context.Context, run *jobs.Run) error {
+		lease := lease
+		if lease == nil {
+			l, err := h.ops.Begin(ctx, name, kind, true)
+			if err != nil {
+				return err
+			}
+			lease = l
+			run.Start(ctx)
+		}
+		defer lease.Release()
+		return fn(ctx, run.Output)
+	})
+	if err != nil && lease != nil {
+		lease.Release()
+	}
+	return job, err
+}
+
+// writeJob responds 202 Accepted with a job that was started
+func writeJob(w http.ResponseWriter, job *jobs.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// jobsHandler serves GET /jobs/{id} and POST /jobs/{id}/cancel
+func (h *Handlers) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" {
+		http.Error(w, "Job ID is required", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job, err := h.jobs.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving job: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+
+	case action == "cancel" && r.Method == http.MethodPost:
+		job, err := h.jobs.Cancel(r.Context(), id)
+		if err == jobs.ErrFinished {
+			http.Error(w, "Job has already finished", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error cancelling job: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+
+	case action == "" || action == "cancel":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// deploymentJobsHandler serves /deployments/{name}/jobs, the deployment's
+// most recent jobs, newest first. ?limit= defaults to 50.
+func (h *Handlers) deploymentJobsHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if arg != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+
+	list, err := h.jobs.List(r.Context(), deployment.Name, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
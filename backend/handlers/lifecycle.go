@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	"main/logs"
+	"main/metrics"
+	"main/revisions"
+	"main/runtime"
+	"main/state"
+	"main/tracing"
+	"main/types"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// portPatterns match the lines runtimes print once a function is listening.
+// They are only used for runtimes that don't accept a port.
+var portPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`Running on host port (\d+)`),
+	regexp.MustCompile(`port (\d+)`),
+	regexp.MustCompile(`listening on port (\d+)`),
+	regexp.MustCompile(`started on port (\d+)`),
+}
+
+// startup tracks a function between launch and port detection so that
+// concurrent callers can wait for the same start
+type startup struct {
+	done chan struct{}
+	err  error
+}
+
+// Wait blocks until the function is running, has failed, or ctx is done
+func (s *startup) Wait(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFunction launches a built deployment and returns a startup that
+// completes once its port is known. If the deployment is already starting,
+// the existing startup is returned. The start is traced as part of ctx,
+// which does not bound it.
+func (h *Handlers) startFunction(ctx context.Context, deployment *types.Deployment, reason string) (*startup, error) {
+	name := deployment.Name
+	var proc *runtime.Process
+	started := time.Now()
+
+	h.cmdMux.Lock()
+	if s, ok := h.startups[name]; ok {
+		h.cmdMux.Unlock()
+		return s, nil
+	}
+	s := &startup{done: make(chan struct{})}
+	h.startups[name] = s
+	h.cmdMux.Unlock()
+
+	ctx, span := tracing.Start(context.WithoutCancel(ctx), "function start", trace.WithAttributes(
+		attribute.String("deployment", name),
+		attribute.String("reason", reason),
+	))
+	// finish completes the startup, only the first time it is called
+	var once sync.Once
+	finish := func(err error) {
+		once.Do(func() {
+			h.cmdMux.Lock()
+			delete(h.startups, name)
+			h.cmdMux.Unlock()
+			s.err = err
+			close(s.done)
+			h.metrics.ObserveOperation("start", deployment.Language, metrics.Outcome(err, false), started)
+			if deployment.Port != "" {
+				span.SetAttributes(attribute.String("port", deployment.Port))
+			}
+			tracing.End(span, err)
+		})
+	}
+
+	// Update status to Starting
+	if err := h.states.Transition(ctx, deployment, state.Starting, reason); err != nil {
+		finish(err)
+		return nil, err
+	}
+
+	// Broadcast starting status
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+
+	fn, err := h.function(ctx, deployment)
+	if err == nil && h.runtime.AcceptsPort() {
+		fn.Port, err = h.ports.Assign(ctx, name)
+	}
+	if err == nil {
+		proc, err = h.runtime.Run(ctx, fn)
+	}
+	if err != nil {
+		h.setFailed(ctx, deployment, fmt.Sprintf("run failed: %v", err))
+		finish(err)
+		return nil, err
+	}
+
+	// Store the process in our runningCmds map so we can stop it later, and
+	// its PID so that it can be found again after a restart
+	h.cmdMux.Lock()
+	h.runningCmds[name] = proc
+	deployment.PID = proc.Pid()
+	if err := h.store.Update(ctx, *deployment); err != nil {
+		slog.Error("Error updating deployment process", "deployment", name, "error", err)
+	}
+	h.cmdMux.Unlock()
+
+	go h.watchStartup(ctx, deployment, proc, finish)
+	return s, nil
+}
+
+// watchStartup captures the function output in the run log until the process
+// exits. Until a port is detected it also scans the output for one, marking
+// the deployment Running, or fails the start when the process exits or no
+// port shows up in time.
+func (h *Handlers) watchStartup(ctx context.Context, deployment *types.Deployment, proc *runtime.Process, finish func(error)) {
+	name := deployment.Name
+	buf := make([]byte, 4096)
+	timeout := h.config.Function.PortDetectionTimeout
+	errorBuffer := bytes.NewBuffer(nil)
+
+	var out io.Writer = io.Discard
+	logw, err := h.logs.Open(name, logs.Run)
+	if err != nil {
+		slog.Error("Error opening run log", "deployment", name, "error", err)
+	} else {
+		defer logw.Close()
+		out = logw
+	}
+
+	// mu guards port and timedOut, shared with the timeout timer
+	var mu sync.Mutex
+	port := ""
+	timedOut := false
+
+	setRunning := func(p string) {
+		h.cmdMux.Lock()
+		deployment.Port = p
+		err := h.states.Transition(ctx, deployment, state.Running, "listening on port "+p)
+		h.cmdMux.Unlock()
+		if err != nil {
+			// The function was stopped while it was starting
+			slog.Warn("Not marking function running", "deployment", name, "error", err)
+			finish(err)
+			return
+		}
+		h.touch(name)
+		h.supervise(name, proc)
+		// Broadcast status update with port
+		h.broadcastMessage(deployment.Name, map[string]interface{}{
+			"type": "status_update",
+			"data": deployment,
+		})
+		finish(nil)
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		mu.Lock()
+		if port != "" || timedOut {
+			mu.Unlock()
+			return
+		}
+		timedOut = true
+		mu.Unlock()
+
+		slog.Warn("No port detected within timeout period", "deployment", name, "timeout", timeout)
+		// Update status to indicate timeout
+		h.setFailed(ctx, deployment, fmt.Sprintf("no port detected within %v", timeout))
+		// Kill the process if it's still running
+		if err := h.runtime.Stop(proc); err != nil {
+			slog.Error("Error stopping function", "deployment", name, "error", err)
+		}
+		h.cmdMux.Lock()
+		delete(h.runningCmds, name)
+		h.cmdMux.Unlock()
+		finish(fmt.Errorf("no port detected within %v", timeout))
+	})
+	defer timer.Stop()
+
+	// Runtimes that pick the port themselves don't need it detected, only
+	// waited for until the function accepts connections
+	if proc.Port != "" {
+		go func() {
+			if !waitListening(proc.Port, proc.Done()) {
+				return
+			}
+			mu.Lock()
+			if timedOut {
+				mu.Unlock()
+				return
+			}
+			port = proc.Port
+			mu.Unlock()
+			setRunning(proc.Port)
+		}()
+	}
+
+	for {
+		n, err := proc.Output.Read(buf)
+		if n > 0 {
+			out.Write(buf[:n])
+
+			mu.Lock()
+			detect := proc.Port == "" && port == "" && !timedOut
+			mu.Unlock()
+
+			// Try to extract port from the output
+			if detect {
+				outputChunk := string(buf[:n])
+				errorBuffer.Write(buf[:n])
+				for _, re := range portPatterns {
+					matches := re.FindStringSubmatch(outputChunk)
+					if len(matches) > 1 {
+						// The timer may have failed the start since detect
+						// was read
+						mu.Lock()
+						found := !timedOut
+						if found {
+							port = matches[1]
+						}
+						mu.Unlock()
+						if found {
+							slog.Debug("Found port", "deployment", name, "pattern", re.String(), "port", matches[1])
+							setRunning(matches[1])
+						}
+						break
+					}
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				slog.Error("Error reading function output", "deployment", name, "error", err)
+			}
+			break
+		}
+	}
+
+	// If we get here, the process has exited
+	mu.Lock()
+	failed := port == "" && !timedOut
+	timedOut = timedOut || failed
+	mu.Unlock()
+	if failed {
+		slog.Warn("Function exited without detecting port", "deployment", name, "output", errorBuffer.String())
+		h.setFailed(ctx, deployment, "function exited before it started listening")
+		finish(errors.New("function exited before it started listening"))
+	}
+}
+
+// runFunction starts a deployment and waits until it is running. If ctx is
+// cancelled first, the function is stopped again.
+func (h *Handlers) runFunction(ctx context.Context, deployment *types.Deployment, reason string, out io.Writer) error {
+	fmt.Fprintln(out, "Starting function")
+	s, err := h.startFunction(ctx, deployment, reason)
+	if err != nil {
+		return err
+	}
+	if err := s.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			if err := h.stopFunction(ctx, deployment, "start cancelled"); err != nil {
+				slog.ErrorContext(ctx, "Error stopping function", "deployment", deployment.Name, "error", err)
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(out, "Running on port %s\n", deployment.Port)
+	return nil
+}
+
+// waitListening polls the port until it accepts connections. It gives up
+// when exited is closed.
+func waitListening(port string, exited <-chan struct{}) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		conn, err := net.DialTimeout("tcp", "localhost:"+port, time.Second)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		select {
+		case <-exited:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// setFailed marks a deployment as Failed and broadcasts the change
+func (h *Handlers) setFailed(ctx context.Context, deployment *types.Deployment, reason string) {
+	h.cmdMux.Lock()
+	deployment.PID = 0
+	err := h.states.Transition(context.WithoutCancel(ctx), deployment, state.Failed, reason)
+	h.cmdMux.Unlock()
+	if err != nil {
+		slog.Error("Error updating deployment status", "deployment", deployment.Name, "error", err)
+		return
+	}
+	// Broadcast failed status
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+}
+
+// stopFunction stops the deployment's process, if any, and marks it Stopped
+func (h *Handlers) stopFunction(ctx context.Context, deployment *types.Deployment, reason string) error {
+	name := deployment.Name
+	// The stop is recorded even if ctx is cancelled meanwhile
+	ctx = context.WithoutCancel(ctx)
+	if err := state.Check(deployment, state.Stopped); err != nil {
+		return err
+	}
+
+	// Stop watching first so that the exit isn't taken for a crash
+	h.unsupervise(name)
+	h.cmdMux.Lock()
+	proc, exists := h.runningCmds[name]
+	h.cmdMux.Unlock()
+
+	if exists {
+		if err := h.runtime.Stop(proc); err != nil {
+			slog.Error("Error stopping function", "deployment", name, "error", err)
+		}
+
+		// Clean up
+		h.cmdMux.Lock()
+		delete(h.runningCmds, name)
+		h.cmdMux.Unlock()
+	}
+	h.forget(name)
+
+	// Update status
+	deployment.Port = ""
+	deployment.PID = 0
+	if err := h.states.Transition(ctx, deployment, state.Stopped, reason); err != nil {
+		return err
+	}
+
+	// Broadcast status update
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+	return nil
+}
+
+// buildFunction marks the deployment Building and builds it, recording the
+// result on its latest revision. The build output is copied to out. Cancelling
+// ctx kills the build.
+func (h *Handlers) buildFunction(ctx context.Context, deployment *types.Deployment, reason string, out io.Writer) error {
+	// Set status to "Building"
+	if err := h.states.Transition(ctx, deployment, state.Building, reason); err != nil {
+		return err
+	}
+
+	// Broadcast building status
+	h.broadcastMessage(deployment.Name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+
+	rev, err := h.revisions.Latest(ctx, deployment.Name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error retrieving latest revision", "deployment", deployment.Name, "error", err)
+	}
+
+	started := time.Now()
+	err = h.build(ctx, deployment, rev, out)
+	h.metrics.ObserveOperation("build", deployment.Language, metrics.Outcome(err, ctx.Err() != nil), started)
+	return err
+}
+
+// build runs the runtime build for a deployment and updates its status
+func (h *Handlers) build(ctx context.Context, d *types.Deployment, rev *revisions.Revision, jobOut io.Writer) error {
+	fnName := d.Name
+	var buildOutput bytes.Buffer
+	out := io.MultiWriter(&buildOutput, jobOut)
+	logw, err := h.logs.Open(fnName, logs.Build)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error opening build log", "deployment", fnName, "error", err)
+	} else {
+		defer logw.Close()
+		out = io.MultiWriter(&buildOutput, jobOut, logw)
+	}
+
+	var result *runtime.BuildResult
+	fn, err := h.function(ctx, d)
+	if err == nil {
+		result, err = h.runtime.Build(ctx, fn, out)
+	}
+	cancelled := ctx.Err() != nil
+	// The result is recorded even if the build was cancelled
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		reason := fmt.Sprintf("build failed: %v", err)
+		if cancelled {
+			reason = "build cancelled"
+		}
+		fmt.Fprintf(out, "Build failed: %v\n", err)
+		slog.ErrorContext(ctx, "Build failed", "deployment", fnName, "error", err, "output", buildOutput.String())
+		if rev != nil {
+			if err := h.revisions.SetBuildResult(ctx, fnName, rev.Number, revisions.BuildFailed, ""); err != nil {
+				slog.ErrorContext(ctx, "Error updating revision", "deployment", fnName, "error", err)
+			}
+		}
+		if err := h.states.Transition(ctx, d, state.Failed, reason); err != nil {
+			slog.ErrorContext(ctx, "Error updating deployment status", "deployment", fnName, "error", err)
+		}
+		// Broadcast status update
+		h.broadcastMessage(d.Name, map[string]interface{}{
+			"type": "status_update",
+			"data": d,
+		})
+		return err
+	}
+	slog.InfoContext(ctx, "Build succeeded", "deployment", fnName, "output", buildOutput.String())
+
+	if rev != nil {
+		if err := h.revisions.SetBuildResult(ctx, fnName, rev.Number, revisions.BuildBuilt, result.Image); err != nil {
+			slog.ErrorContext(ctx, "Error updating revision", "deployment", fnName, "error", err)
+		}
+	}
+
+	// Update status to "Built" after successful build
+	d.Built = true
+	if err := h.states.Transition(ctx, d, state.Stopped, "build succeeded"); err != nil {
+		slog.ErrorContext(ctx, "Error updating deployment status", "deployment", fnName, "error", err)
+	}
+	// Broadcast status update
+	h.broadcastMessage(d.Name, map[string]interface{}{
+		"type": "build_complete",
+		"data": d,
+	})
+	return nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"main/runtime"
+	"main/state"
+	"main/types"
+)
+
+// TestStartupTimeoutRace times out port detection while the output holding
+// the port is being scanned, so that the timer fails the start after the
+// scan began but before the port is found. The start must finish once, as
+// failed.
+func TestStartupTimeoutRace(t *testing.T) {
+	s := newTestServer(t)
+	s.h.config.Function.PortDetectionTimeout = time.Millisecond
+	ctx := context.Background()
+
+	// A pattern that takes tens of milliseconds to not match keeps the scan
+	// busy past the timeout
+	patterns := portPatterns
+	t.Cleanup(func() { portPatterns = patterns })
+	portPatterns = append([]*regexp.Regexp{regexp.MustCompile(`.{1000}#`)}, patterns...)
+
+	d := &types.Deployment{Name: "hello", Language: "go", Status: string(state.Starting)}
+	if err := s.store.Create(ctx, *d); err != nil {
+		t.Fatal(err)
+	}
+	output, w := io.Pipe()
+	go func() {
+		w.Write(append(bytes.Repeat([]byte("."), 4000), "\nlistening on port 8000\n"...))
+		w.Close()
+	}()
+
+	var calls atomic.Int32
+	errs := make(chan error, 2)
+	s.h.watchStartup(ctx, d, &runtime.Process{Name: "hello", Output: output}, func(err error) {
+		calls.Add(1)
+		errs <- err
+	})
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "no port detected") {
+		t.Fatalf("got %v, want the start to time out", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("start finished %d times, want once", n)
+	}
+	s.expectStatus("hello", state.Failed)
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/logs"
+	"main/types"
+)
+
+// logsHandler serves /deployments/{name}/logs and
+// /deployments/{name}/logs/attempts.
+//
+// Query parameters: kind (build|run), attempt, tail (number of lines),
+// since (RFC3339 time or a duration such as 10m) and follow. With
+// follow=true new lines are streamed as server-sent events when the client
+// accepts text/event-stream, and as newline-delimited JSON otherwise.
+func (h *Handlers) logsHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := deployment.Name
+	q := r.URL.Query()
+
+	kind := logs.Kind(q.Get("kind"))
+	if kind != "" && kind != logs.Build && kind != logs.Run {
+		http.Error(w, "kind must be build or run", http.StatusBadRequest)
+		return
+	}
+
+	switch arg {
+	case "":
+	case "attempts":
+		attempts, err := h.logs.Attempts(name, kind)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving log attempts: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(attempts)
+		return
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	query := logs.Query{Kind: kind, Attempt: q.Get("attempt")}
+	if tail := q.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			http.Error(w, "tail must be a positive number", http.StatusBadRequest)
+			return
+		}
+		query.Tail = n
+	}
+	if since := q.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			http.Error(w, "since must be an RFC3339 time or a duration", http.StatusBadRequest)
+			return
+		}
+		query.Since = t
+	}
+	follow := q.Get("follow") == "true" || q.Get("follow") == "1"
+
+	if !follow {
+		lines, err := h.logs.Read(name, query)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading logs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lines)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the backlog so no line falls in between
+	ch, cancel := h.logs.Subscribe(name)
+	defer cancel()
+
+	backlog, err := h.logs.Read(name, query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading logs: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(l logs.Line) bool {
+		data, _ := json.Marshal(l)
+		var err error
+		if sse {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+		return err == nil
+	}
+
+	var last time.Time
+	for _, l := range backlog {
+		if !send(l) {
+			return
+		}
+		last = l.Time
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.closing:
+			return
+		case <-keepalive.C:
+			if sse {
+				fmt.Fprint(w, ": keepalive\n\n")
+			}
+			flusher.Flush()
+		case l, ok := <-ch:
+			if !ok {
+				return
+			}
+			if (kind != "" && l.Kind != kind) || (query.Attempt != "" && l.Attempt != query.Attempt) {
+				continue
+			}
+			// Skip lines already sent as part of the backlog
+			if !l.Time.After(last) {
+				continue
+			}
+			if !send(l) {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseSince accepts an RFC3339 timestamp or a duration relative to now
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-d), nil
+}
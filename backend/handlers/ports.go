@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// portsHandler serves GET /ports, the ports assigned to deployments
+func (h *Handlers) portsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	assignments, err := h.ports.List(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving port assignments: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assignments)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"main/health"
+	"main/revisions"
+	"main/runtime"
+	"main/state"
+	"main/types"
+)
+
+// Reconcile corrects the recorded state of every deployment when the
+// backend starts. Functions recorded as Running whose process survived the
+// restart are adopted again; any other leftover process is stopped, and
+// deployments stuck in Running, Starting or Building are reset. Corrections
+// are broadcast as status updates.
+func (h *Handlers) Reconcile(ctx context.Context) error {
+	deployments, err := h.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving deployments: %v", err)
+	}
+	for i := range deployments {
+		h.reconcile(ctx, &deployments[i])
+	}
+	return nil
+}
+
+func (h *Handlers) reconcile(ctx context.Context, deployment *types.Deployment) {
+	name := deployment.Name
+	before := *deployment
+
+	var proc *runtime.Process
+	if deployment.PID > 0 {
+		fn, err := h.function(ctx, deployment)
+		if err == nil {
+			proc, err = h.runtime.Adopt(fn, deployment.PID, deployment.Port)
+		}
+		if err != nil && err != runtime.ErrNotRunning {
+			slog.Error("Error adopting process", "deployment", name, "pid", deployment.PID, "error", err)
+		}
+	}
+
+	to := state.Of(deployment)
+	reason := "backend restarted"
+	switch to {
+	case state.Running, state.Unhealthy:
+		if proc != nil && deployment.Port != "" && listening(deployment.Port) {
+			h.cmdMux.Lock()
+			h.runningCmds[name] = proc
+			h.cmdMux.Unlock()
+			h.touch(name)
+			h.supervise(name, proc)
+			slog.Info("Adopted running process", "deployment", name, "pid", deployment.PID, "port", deployment.Port)
+			return
+		}
+		to = state.Stopped
+	case state.Crashed:
+		// Restarts that were waiting for their backoff died with the
+		// previous backend
+		if _, policy := health.Resolve(deployment, h.config); health.ShouldRestart(policy, true) {
+			defer h.scheduleRestart(name)
+		}
+	case state.Creating:
+		// The create job was failed by the job manager, leaving the
+		// function directory incomplete
+		to = state.Failed
+		reason = "creation interrupted by a backend restart"
+	case state.Starting:
+		to = state.Stopped
+	case state.Building:
+		// The build died with the previous backend and its outcome is unknown
+		to = state.Failed
+		h.failPendingBuild(ctx, name)
+	}
+
+	if proc != nil {
+		slog.Info("Stopping leftover process", "deployment", name, "pid", deployment.PID)
+		if err := h.runtime.Stop(proc); err != nil {
+			slog.Error("Error stopping leftover process", "deployment", name, "error", err)
+		}
+	}
+	deployment.PID = 0
+	deployment.Port = ""
+	if to == state.Of(deployment) {
+		if *deployment != before {
+			if err := h.store.Update(ctx, *deployment); err != nil {
+				slog.Error("Error updating deployment", "deployment", name, "error", err)
+			}
+		}
+		return
+	}
+
+	slog.Info("Reconciled status", "deployment", name, "from", before.Status, "to", to)
+	if err := h.states.Transition(ctx, deployment, to, reason); err != nil {
+		slog.Error("Error updating deployment status", "deployment", name, "error", err)
+		return
+	}
+	h.broadcastMessage(name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+}
+
+// failPendingBuild marks the latest revision failed if its build never
+// finished
+func (h *Handlers) failPendingBuild(ctx context.Context, name string) {
+	rev, err := h.revisions.Latest(ctx, name)
+	if err != nil {
+		slog.Error("Error retrieving latest revision", "deployment", name, "error", err)
+		return
+	}
+	if rev == nil || rev.BuildStatus != revisions.BuildPending {
+		return
+	}
+	if err := h.revisions.SetBuildResult(ctx, name, rev.Number, revisions.BuildFailed, ""); err != nil {
+		slog.Error("Error updating revision", "deployment", name, "error", err)
+	}
+}
+
+// listening reports whether something accepts connections on the port
+func listening(port string) bool {
+	conn, err := net.DialTimeout("tcp", "localhost:"+port, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"main/state"
+	"main/types"
+)
+
+func TestReconcileInterruptedCreate(t *testing.T) {
+	s := newTestServer(t)
+	d := &types.Deployment{ID: "1", Name: "hello", Language: "go"}
+	if err := s.h.states.Create(context.Background(), d, "create requested"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.h.Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.expectStatus("hello", state.Failed)
+
+	transitions, err := s.store.Transitions(context.Background(), "hello", 1)
+	if err != nil || len(transitions) != 1 {
+		t.Fatalf("got transitions %v, error %v", transitions, err)
+	}
+	if got := transitions[0].Reason; got != "creation interrupted by a backend restart" {
+		t.Fatalf("got reason %q", got)
+	}
+}
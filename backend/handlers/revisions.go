@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"main/ops"
+	"main/revisions"
+	"main/state"
+	"main/types"
+)
+
+// snapshot records the deployment's current code and package file as a new
+// revision. rollbackOf is the revision being restored, or 0.
+func (h *Handlers) snapshot(ctx context.Context, deployment *types.Deployment, rollbackOf int) (*revisions.Revision, error) {
+	codeFile, pkgFile := getLanguageSpecificFiles(deployment.Language)
+	dir := filepath.Join(h.config.Function.DataDir, deployment.Name)
+
+	code, err := os.ReadFile(filepath.Join(dir, codeFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading code file: %v", err)
+	}
+	pkg, err := os.ReadFile(filepath.Join(dir, pkgFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading package file: %v", err)
+	}
+
+	return h.revisions.Create(ctx, revisions.Revision{
+		Deployment:  deployment.Name,
+		CodeFile:    codeFile,
+		PackageFile: pkgFile,
+		Code:        string(code),
+		Package:     string(pkg),
+		RollbackOf:  rollbackOf,
+	})
+}
+
+// revisionsHandler serves /deployments/{name}/revisions and
+// /deployments/{name}/revisions/{n}[/diff|/rollback].
+//
+// GET .../{n}/diff compares revision n with the one given by ?against=,
+// which defaults to n-1. POST .../{n}/rollback restores the files of a
+// successfully built revision as a new revision and rebuilds it, restarting
+// the function if it was running.
+func (h *Handlers) revisionsHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	name := deployment.Name
+	if arg == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		revs, err := h.revisions.List(r.Context(), name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving revisions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revs)
+		return
+	}
+
+	numArg, action, _ := strings.Cut(arg, "/")
+	number, err := strconv.Atoi(numArg)
+	if err != nil || number < 1 {
+		http.Error(w, "Invalid revision number", http.StatusBadRequest)
+		return
+	}
+	rev, err := h.revisions.Get(r.Context(), name, number)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving revision: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if rev == nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rev)
+
+	case action == "diff" && r.Method == http.MethodGet:
+		h.revisionDiff(w, r, rev)
+
+	case action == "rollback" && r.Method == http.MethodPost:
+		h.rollback(w, r, deployment.Name, rev)
+
+	case action == "" || action == "diff" || action == "rollback":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func (h *Handlers) revisionDiff(w http.ResponseWriter, r *http.Request, rev *revisions.Revision) {
+	against := rev.Number - 1
+	if a := r.URL.Query().Get("against"); a != "" {
+		n, err := strconv.Atoi(a)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid revision number", http.StatusBadRequest)
+			return
+		}
+		against = n
+	}
+
+	// Diffing the first revision against nothing shows all of its content
+	base := &revisions.Revision{CodeFile: rev.CodeFile, PackageFile: rev.PackageFile}
+	if against > 0 {
+		var err error
+		base, err = h.revisions.Get(r.Context(), rev.Deployment, against)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving revision: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if base == nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	label := func(n int, file string) string {
+		return fmt.Sprintf("r%d/%s", n, file)
+	}
+	w.Header().Set("Content-Type", "text/x-diff")
+	fmt.Fprint(w, revisions.Diff(label(against, base.CodeFile), label(rev.Number, rev.CodeFile), base.Code, rev.Code))
+	fmt.Fprint(w, revisions.Diff(label(against, base.PackageFile), label(rev.Number, rev.PackageFile), base.Package, rev.Package))
+}
+
+func (h *Handlers) rollback(w http.ResponseWriter, r *http.Request, name string, rev *revisions.Revision) {
+	if rev.BuildStatus != revisions.BuildBuilt {
+		http.Error(w, "Only successfully built revisions can be rolled back to", http.StatusConflict)
+		return
+	}
+
+	// Without ?queue=true, fail now rather than in the job
+	if !queued(r) {
+		deployment, err := h.store.Get(r.Context(), name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if deployment == nil {
+			http.Error(w, "Deployment not found", http.StatusNotFound)
+			return
+		}
+		if !state.Up(deployment) {
+			if err := state.Check(deployment, state.Building); err != nil {
+				writeStatusError(w, err)
+				return
+			}
+		}
+	}
+
+	// The job lasts until the build, and the restart if any, is done
+	job, err := h.startJob(r, name, ops.Rollback, func(ctx context.Context, out io.Writer) error {
+		return h.rollbackFunction(ctx, name, rev, out)
+	})
+	if err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	writeJob(w, job)
+}
+
+// rollbackFunction restores a revision's files, rebuilds the deployment and
+// restarts it if it was running
+func (h *Handlers) rollbackFunction(ctx context.Context, name string, rev *revisions.Revision, out io.Writer) error {
+	// Operations queued before this one may have changed the deployment
+	deployment, err := h.store.Get(ctx, name)
+	if err != nil || deployment == nil {
+		return fmt.Errorf("deployment not found: %v", err)
+	}
+
+	reason := fmt.Sprintf("rollback to revision %d", rev.Number)
+	wasRunning := state.Up(deployment)
+	if wasRunning {
+		fmt.Fprintln(out, "Stopping function")
+		if err := h.stopFunction(ctx, deployment, reason); err != nil {
+			return err
+		}
+	} else if err := state.Check(deployment, state.Building); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(h.config.Function.DataDir, deployment.Name)
+	if err := os.WriteFile(filepath.Join(dir, rev.CodeFile), []byte(rev.Code), 0644); err != nil {
+		return fmt.Errorf("error restoring code file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, rev.PackageFile), []byte(rev.Package), 0644); err != nil {
+		return fmt.Errorf("error restoring package file: %v", err)
+	}
+
+	restored, err := h.snapshot(ctx, deployment, rev.Number)
+	if err != nil {
+		return fmt.Errorf("error recording revision: %v", err)
+	}
+	fmt.Fprintf(out, "Restored revision %d as revision %d\n", rev.Number, restored.Number)
+
+	if err := h.buildFunction(ctx, deployment, reason, out); err != nil {
+		return err
+	}
+	if !wasRunning {
+		return nil
+	}
+	return h.runFunction(ctx, deployment, reason, out)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"main/ops"
+	"main/state"
+	"main/types"
+)
+
+// errNotBuilt is returned when a cold start is requested for a function
+// that has never been built
+var errNotBuilt = errors.New("function needs to be built first")
+
+// activity records when a running function was last used and how many
+// invocations are currently in flight
+type activity struct {
+	last     time.Time
+	inflight int
+}
+
+// touch marks a function as used now
+func (h *Handlers) touch(name string) {
+	h.activityMux.Lock()
+	defer h.activityMux.Unlock()
+	a, ok := h.activity[name]
+	if !ok {
+		a = &activity{}
+		h.activity[name] = a
+	}
+	a.last = time.Now()
+}
+
+// forget drops the activity record of a stopped function
+func (h *Handlers) forget(name string) {
+	h.activityMux.Lock()
+	delete(h.activity, name)
+	h.activityMux.Unlock()
+}
+
+// beginInvocation marks an invocation as in flight. The returned func must
+// be called when the invocation completes.
+func (h *Handlers) beginInvocation(name string) func() {
+	h.activityMux.Lock()
+	a, ok := h.activity[name]
+	if !ok {
+		a = &activity{}
+		h.activity[name] = a
+	}
+	a.inflight++
+	a.last = time.Now()
+	h.activityMux.Unlock()
+
+	return func() {
+		h.activityMux.Lock()
+		a.inflight--
+		a.last = time.Now()
+		h.activityMux.Unlock()
+	}
+}
+
+// isIdle reports whether a function has had no invocations for idleTimeout
+func (h *Handlers) isIdle(name string, idleTimeout time.Duration) bool {
+	h.activityMux.Lock()
+	defer h.activityMux.Unlock()
+	a, ok := h.activity[name]
+	return ok && a.inflight == 0 && time.Since(a.last) > idleTimeout
+}
+
+// ensureRunning returns the deployment once it is running, cold starting it
+// and waiting for its port if it is stopped
+func (h *Handlers) ensureRunning(ctx context.Context, name string) (*types.Deployment, error) {
+	deployment, err := h.store.Get(ctx, name)
+	if err != nil || deployment == nil {
+		return deployment, err
+	}
+	if state.Up(deployment) && deployment.Port != "" {
+		return deployment, nil
+	}
+
+	// Wait for any operation in progress, such as another cold start
+	lease, err := h.ops.Begin(ctx, name, ops.Start, true)
+	if err != nil {
+		return nil, err
+	}
+	deployment, err = h.store.Get(ctx, name)
+	if err != nil || deployment == nil {
+		lease.Release()
+		return deployment, err
+	}
+	if state.Up(deployment) && deployment.Port != "" {
+		lease.Release()
+		return deployment, nil
+	}
+	if !deployment.Built {
+		lease.Release()
+		return nil, errNotBuilt
+	}
+
+	startedAt := time.Now()
+	s, err := h.startFunction(ctx, deployment, "cold start for invocation")
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	// The operation lasts until the start completes, even if ctx is done
+	go func() {
+		s.Wait(context.Background())
+		lease.Release()
+	}()
+	if err := s.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("cold start failed: %v", err)
+	}
+	duration := time.Since(startedAt)
+	slog.Info("Cold start completed", "deployment", name, "duration_ms", duration.Milliseconds())
+
+	coldStart := types.ColdStart{
+		StartedAt:  startedAt.Format(time.RFC3339),
+		DurationMs: duration.Milliseconds(),
+	}
+	if err := h.store.RecordColdStart(ctx, name, coldStart); err != nil {
+		slog.Error("Error recording cold start", "deployment", name, "error", err)
+	}
+	return h.store.Get(ctx, name)
+}
+
+// RunIdleReaper stops running functions that have not been invoked for
+// Function.IdleTimeout until ctx is done. It returns immediately when
+// scale-to-zero is disabled.
+func (h *Handlers) RunIdleReaper(ctx context.Context) {
+	idleTimeout := h.config.Function.IdleTimeout
+	if idleTimeout <= 0 {
+		return
+	}
+
+	interval := idleTimeout / 4
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reapIdle(idleTimeout)
+		}
+	}
+}
+
+func (h *Handlers) reapIdle(idleTimeout time.Duration) {
+	var idle []string
+	h.activityMux.Lock()
+	for name, a := range h.activity {
+		if a.inflight == 0 && time.Since(a.last) > idleTimeout {
+			idle = append(idle, name)
+		}
+	}
+	h.activityMux.Unlock()
+
+	for _, name := range idle {
+		// An invocation may have arrived since the scan
+		if !h.isIdle(name, idleTimeout) {
+			continue
+		}
+		// Leave functions alone while another operation is in progress
+		lease, err := h.ops.Begin(context.Background(), name, ops.Stop, false)
+		if err != nil {
+			continue
+		}
+		h.stopIdle(context.Background(), name, idleTimeout)
+		lease.Release()
+	}
+}
+
+func (h *Handlers) stopIdle(ctx context.Context, name string, idleTimeout time.Duration) {
+	deployment, err := h.store.Get(ctx, name)
+	if err != nil {
+		slog.Error("Error retrieving deployment", "deployment", name, "error", err)
+		return
+	}
+	if deployment == nil || !state.Up(deployment) {
+		h.forget(name)
+		return
+	}
+	slog.Info("Stopping idle function", "deployment", name, "idle", idleTimeout.String())
+	if err := h.stopFunction(ctx, deployment, fmt.Sprintf("idle for %v", idleTimeout)); err != nil {
+		slog.Error("Error stopping idle function", "deployment", name, "error", err)
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"main/schedules"
+	"main/types"
+)
+
+// StartSchedules starts invoking functions on their schedules
+func (h *Handlers) StartSchedules() error {
+	return h.schedules.Start(h.invokeSchedule)
+}
+
+// invokeSchedule calls a deployment's function for a schedule, cold
+// starting it if it is stopped
+func (h *Handlers) invokeSchedule(ctx context.Context, sc schedules.Schedule) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("X-Schedule-ID", sc.ID)
+	return h.callFunction(ctx, sc.Deployment, sc.Method, sc.Path, header, []byte(sc.Body))
+}
+
+// scheduleRequest is the body of POST and PUT /deployments/{name}/schedules
+type scheduleRequest struct {
+	Cron   string `json:"cron"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body"`
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled"`
+}
+
+func (req scheduleRequest) schedule(deployment, id string) schedules.Schedule {
+	return schedules.Schedule{
+		ID:         id,
+		Deployment: deployment,
+		Cron:       req.Cron,
+		Method:     req.Method,
+		Path:       req.Path,
+		Body:       req.Body,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+	}
+}
+
+// schedulesHandler serves /deployments/{name}/schedules:
+//
+//	GET|POST   /deployments/{name}/schedules
+//	GET|PUT|DELETE /deployments/{name}/schedules/{id}
+//	GET        /deployments/{name}/schedules/{id}/runs
+func (h *Handlers) schedulesHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	id, sub, _ := strings.Cut(arg, "/")
+	name := deployment.Name
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		list, err := h.schedules.List(r.Context(), name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving schedules: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case id == "" && r.Method == http.MethodPost:
+		var req scheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := schedules.Validate(&schedules.Schedule{Cron: req.Cron, Path: req.Path}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sc, err := h.schedules.Create(r.Context(), req.schedule(name, ""))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error creating schedule: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sc)
+
+	case id == "":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	case sub == "" && r.Method == http.MethodGet:
+		sc, err := h.schedules.Get(r.Context(), name, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving schedule: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if sc == nil {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sc)
+
+	case sub == "" && r.Method == http.MethodPut:
+		var req scheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := schedules.Validate(&schedules.Schedule{Cron: req.Cron, Path: req.Path}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sc, err := h.schedules.Update(r.Context(), req.schedule(name, id))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error updating schedule: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if sc == nil {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sc)
+
+	case sub == "" && r.Method == http.MethodDelete:
+		found, err := h.schedules.Delete(r.Context(), name, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error deleting schedule: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case sub == "":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	case sub == "runs" && r.Method == http.MethodGet:
+		sc, err := h.schedules.Get(r.Context(), name, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving schedule: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if sc == nil {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		limit, ok := limitParam(w, r)
+		if !ok {
+			return
+		}
+		runs, err := h.schedules.Runs(r.Context(), id, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving schedule runs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runs)
+
+	case sub == "runs":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
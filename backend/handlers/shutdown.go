@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"main/state"
+
+	"github.com/gorilla/websocket"
+)
+
+// Shutdown modes for running functions
+const (
+	ShutdownStop   = "stop"
+	ShutdownDetach = "detach"
+)
+
+// CloseConnections ends the connections that the HTTP server does not
+// drain by itself: log streams are ended and WebSocket clients are sent a
+// close frame and disconnected. It is meant for http.Server.RegisterOnShutdown.
+func (h *Handlers) CloseConnections() {
+	h.closeOnce.Do(func() {
+		close(h.closing)
+	})
+
+	h.clientsMux.Lock()
+	clients := make([]*wsClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.clientsMux.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, client := range clients {
+		client.writeMu.Lock()
+		err := client.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		client.writeMu.Unlock()
+		if err != nil {
+			slog.Warn("Error closing WebSocket client", "error", err)
+		}
+		client.conn.Close()
+	}
+	slog.Info("Closed WebSocket clients", "clients", len(clients))
+}
+
+// Shutdown stops watching running functions and then stops them, or leaves
+// them running for the next backend to adopt when Shutdown.Functions is
+// detach and the runtime allows it. Jobs should have finished already, so
+// that no function is being started or stopped at the same time.
+//
+// Shutdown does not return before every function has stopped, so that none
+// outlives the backend: the runtime kills those that ignore the interrupt.
+// An error is returned if that took longer than ctx allows.
+func (h *Handlers) Shutdown(ctx context.Context) error {
+	h.supervisorMux.Lock()
+	for name, s := range h.supervisors {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		delete(h.supervisors, name)
+	}
+	h.supervisorMux.Unlock()
+
+	h.cmdMux.Lock()
+	names := make([]string, 0, len(h.runningCmds))
+	for name := range h.runningCmds {
+		names = append(names, name)
+	}
+	h.cmdMux.Unlock()
+
+	if h.config.Shutdown.Functions == ShutdownDetach {
+		if h.runtime.Detachable() {
+			// The deployments stay Running with their PID and port, which
+			// is what Reconcile looks for when adopting processes
+			for _, name := range names {
+				slog.Info("Leaving function running", "deployment", name)
+			}
+			return nil
+		}
+		slog.Warn("Function runtime cannot leave functions running, stopping them", "runtime", h.config.Function.Runtime)
+	}
+
+	// The stops go on once ctx is done
+	stopCtx := context.WithoutCancel(ctx)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			h.stopOnShutdown(stopCtx, name)
+		}(name)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		slog.Warn("Functions are still stopping, waiting for them to exit or be killed", "error", ctx.Err())
+		<-done
+		return fmt.Errorf("error stopping functions: %v", ctx.Err())
+	}
+}
+
+func (h *Handlers) stopOnShutdown(ctx context.Context, name string) {
+	deployment, err := h.store.Get(ctx, name)
+	if err != nil || deployment == nil {
+		slog.Error("Error retrieving deployment", "deployment", name, "error", err)
+		return
+	}
+	if state.Check(deployment, state.Stopped) != nil {
+		return
+	}
+	slog.Info("Stopping function", "deployment", name)
+	if err := h.stopFunction(ctx, deployment, "backend shutting down"); err != nil {
+		slog.Error("Error stopping function", "deployment", name, "error", err)
+	}
+}
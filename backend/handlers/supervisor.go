@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"main/health"
+	"main/ops"
+	"main/runtime"
+	"main/state"
+	"main/types"
+)
+
+// supervisor watches the process of a running function. It is kept across
+// automatic restarts so that consecutive ones can be counted.
+type supervisor struct {
+	// ctx is cancelled to stop watching; it is nil while no process is
+	// watched
+	ctx    context.Context
+	cancel context.CancelFunc
+	// since is when the watched process came up
+	since     time.Time
+	restarts  int
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+// supervise watches a running function's process, restarting the watch with
+// the deployment's current settings if it is already watched
+func (h *Handlers) supervise(name string, proc *runtime.Process) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.supervisorMux.Lock()
+	s, ok := h.supervisors[name]
+	if !ok {
+		s = &supervisor{}
+		h.supervisors[name] = s
+	}
+	if s.cancel != nil {
+		s.cancel()
+	} else {
+		s.since = time.Now()
+	}
+	s.ctx, s.cancel = ctx, cancel
+	s.failures = 0
+	h.supervisorMux.Unlock()
+
+	go h.watch(ctx, name, proc)
+}
+
+// unsupervise stops watching a function that is being stopped on purpose,
+// which also resets its restart count
+func (h *Handlers) unsupervise(name string) {
+	h.supervisorMux.Lock()
+	defer h.supervisorMux.Unlock()
+	if s, ok := h.supervisors[name]; ok {
+		if s.cancel != nil {
+			s.cancel()
+		}
+		delete(h.supervisors, name)
+	}
+}
+
+// watch waits for the process to exit and probes it every health check
+// interval until ctx is cancelled
+func (h *Handlers) watch(ctx context.Context, name string, proc *runtime.Process) {
+	deployment, err := h.store.Get(ctx, name)
+	if err != nil || deployment == nil {
+		return
+	}
+	check, policy := health.Resolve(deployment, h.config)
+	port := deployment.Port
+
+	var probes <-chan time.Time
+	if check.Type != health.TypeNone {
+		ticker := time.NewTicker(time.Duration(check.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		probes = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-proc.Done():
+			if ctx.Err() != nil {
+				return
+			}
+			// Adopted processes can't be waited for, so their exit status
+			// is unknown
+			exitErr := proc.Err()
+			failed := exitErr != nil || proc.Cmd == nil
+			reason := "function exited"
+			if exitErr != nil {
+				reason = fmt.Sprintf("function exited: %v", exitErr)
+			}
+			slog.Warn("Function exited", "deployment", name, "reason", reason)
+			h.crashed(ctx, name, reason, health.ShouldRestart(policy, failed))
+			return
+
+		case <-probes:
+			err := health.Probe(ctx, port, check)
+			if ctx.Err() != nil {
+				return
+			}
+			failures := h.recordCheck(name, err)
+			if err == nil {
+				h.setHealth(ctx, name, state.Running, "health check passed")
+				continue
+			}
+			if failures < check.FailureThreshold {
+				continue
+			}
+			reason := fmt.Sprintf("%d consecutive health checks failed: %v", failures, err)
+			h.setHealth(ctx, name, state.Unhealthy, reason)
+			if policy == health.RestartNever {
+				continue
+			}
+
+			slog.Warn("Killing unhealthy function", "deployment", name, "reason", reason)
+			if err := h.runtime.Stop(proc); err != nil {
+				slog.Error("Error stopping function", "deployment", name, "error", err)
+			}
+			h.crashed(ctx, name, "killed after failing health checks", true)
+			return
+		}
+	}
+}
+
+// recordCheck records the result of a health check and returns the number
+// of consecutive failed checks
+func (h *Handlers) recordCheck(name string, err error) int {
+	h.supervisorMux.Lock()
+	defer h.supervisorMux.Unlock()
+	s, ok := h.supervisors[name]
+	if !ok {
+		return 0
+	}
+	s.lastCheck = time.Now()
+	if err == nil {
+		s.failures = 0
+		s.lastError = ""
+		return 0
+	}
+	s.failures++
+	s.lastError = err.Error()
+	return s.failures
+}
+
+// setHealth moves a running function between Running and Unhealthy
+func (h *Handlers) setHealth(ctx context.Context, name string, to state.Status, reason string) {
+	h.cmdMux.Lock()
+	deployment, err := h.store.Get(ctx, name)
+	if err != nil || deployment == nil || !state.Up(deployment) || state.Of(deployment) == to {
+		h.cmdMux.Unlock()
+		return
+	}
+	err = h.states.Transition(ctx, deployment, to, reason)
+	h.cmdMux.Unlock()
+	if err != nil {
+		slog.Error("Error updating deployment status", "deployment", name, "error", err)
+		return
+	}
+	h.broadcastMessage(name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+}
+
+// crashed marks a function whose process is gone as Crashed and, if restart
+// is set, schedules a restart
+func (h *Handlers) crashed(ctx context.Context, name string, reason string, restart bool) {
+	h.cmdMux.Lock()
+	deployment, err := h.store.Get(ctx, name)
+	// The function may have been stopped on purpose meanwhile
+	if err != nil || deployment == nil || !state.Up(deployment) || ctx.Err() != nil {
+		h.cmdMux.Unlock()
+		return
+	}
+	delete(h.runningCmds, name)
+	deployment.Port = ""
+	deployment.PID = 0
+	err = h.states.Transition(ctx, deployment, state.Crashed, reason)
+	h.cmdMux.Unlock()
+	if err != nil {
+		slog.Error("Error updating deployment status", "deployment", name, "error", err)
+		return
+	}
+	h.forget(name)
+
+	h.supervisorMux.Lock()
+	if s, ok := h.supervisors[name]; ok && s.ctx == ctx {
+		s.cancel()
+		s.ctx, s.cancel = nil, nil
+	}
+	h.supervisorMux.Unlock()
+
+	h.broadcastMessage(name, map[string]interface{}{
+		"type": "status_update",
+		"data": deployment,
+	})
+	if restart {
+		h.scheduleRestart(name)
+	}
+}
+
+// scheduleRestart restarts a crashed function after the restart backoff
+func (h *Handlers) scheduleRestart(name string) {
+	h.supervisorMux.Lock()
+	s, ok := h.supervisors[name]
+	if !ok {
+		s = &supervisor{}
+		h.supervisors[name] = s
+	}
+	// A function that stayed up long enough starts over with a short delay
+	if time.Since(s.since) >= h.config.Health.MaxRestartBackoff {
+		s.restarts = 0
+	}
+	s.restarts++
+	n := s.restarts
+	h.supervisorMux.Unlock()
+
+	delay := health.Backoff(n, h.config.Health.RestartBackoff, h.config.Health.MaxRestartBackoff)
+	slog.Info("Restarting function", "deployment", name, "delay", delay.String(), "restart", n)
+	time.AfterFunc(delay, func() { h.restart(name, n) })
+}
+
+// restart starts a crashed function again as a job, unless another
+// operation has dealt with it in the meantime
+func (h *Handlers) restart(name string, n int) {
+	stillCrashed := func() (*types.Deployment, bool) {
+		deployment, err := h.store.Get(context.Background(), name)
+		if err != nil {
+			slog.Error("Error retrieving deployment", "deployment", name, "error", err)
+			return nil, false
+		}
+		return deployment, deployment != nil && state.Of(deployment) == state.Crashed
+	}
+	if _, ok := stillCrashed(); !ok {
+		return
+	}
+
+	reason := fmt.Sprintf("restart %d after crash", n)
+	_, err := h.runJob(context.Background(), name, ops.Restart, true, func(ctx context.Context, out io.Writer) error {
+		deployment, ok := stillCrashed()
+		if !ok {
+			fmt.Fprintln(out, "Function is no longer crashed, not restarting")
+			return nil
+		}
+		return h.runFunction(ctx, deployment, reason, out)
+	})
+	if err != nil {
+		slog.Error("Error restarting function", "deployment", name, "error", err)
+	}
+}
+
+// healthStatus is the response of /deployments/{name}/health
+type healthStatus struct {
+	Status string `json:"status"`
+	// RestartPolicy and HealthCheck are the effective settings, with the
+	// defaults filled in
+	RestartPolicy       string            `json:"restartPolicy"`
+	HealthCheck         types.HealthCheck `json:"healthCheck"`
+	ConsecutiveFailures int               `json:"consecutiveFailures"`
+	Restarts            int               `json:"restarts"`
+	LastCheckAt         string            `json:"lastCheckAt,omitempty"`
+	LastError           string            `json:"lastError,omitempty"`
+}
+
+// healthSettings is the body of PUT /deployments/{name}/health
+type healthSettings struct {
+	RestartPolicy string            `json:"restartPolicy"`
+	HealthCheck   types.HealthCheck `json:"healthCheck"`
+}
+
+// healthHandler serves /deployments/{name}/health. GET reports the health
+// of the function and PUT changes its health check and restart policy.
+func (h *Handlers) healthHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if arg != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings healthSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := health.Validate(settings.HealthCheck, settings.RestartPolicy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		deployment.RestartPolicy = settings.RestartPolicy
+		deployment.HealthCheck = settings.HealthCheck
+		if err := h.store.UpdateHealth(r.Context(), *deployment); err != nil {
+			http.Error(w, fmt.Sprintf("Error saving health settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Apply the new settings to a watched function right away
+		h.cmdMux.Lock()
+		proc, running := h.runningCmds[deployment.Name]
+		h.cmdMux.Unlock()
+		h.supervisorMux.Lock()
+		s, ok := h.supervisors[deployment.Name]
+		watched := ok && s.cancel != nil
+		h.supervisorMux.Unlock()
+		if running && watched {
+			h.supervise(deployment.Name, proc)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	check, policy := health.Resolve(deployment, h.config)
+	status := healthStatus{
+		Status:        deployment.Status,
+		RestartPolicy: policy,
+		HealthCheck:   check,
+	}
+	h.supervisorMux.Lock()
+	if s, ok := h.supervisors[deployment.Name]; ok {
+		status.ConsecutiveFailures = s.failures
+		status.Restarts = s.restarts
+		status.LastError = s.lastError
+		if !s.lastCheck.IsZero() {
+			status.LastCheckAt = s.lastCheck.Format(time.RFC3339)
+		}
+	}
+	h.supervisorMux.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"main/queue"
+	"main/topics"
+	"main/tracing"
+	"main/types"
+
+	"github.com/google/uuid"
+)
+
+// event is the response of POST /topics/{topic}/publish
+type event struct {
+	ID    string `json:"id"`
+	Topic string `json:"topic"`
+	// Invocations are the asynchronous invocations queued for the
+	// subscribers, one per subscription
+	Invocations []string `json:"invocations"`
+}
+
+// subscriptionRequest is the body of POST /topics/{topic}/subscriptions
+type subscriptionRequest struct {
+	Deployment string `json:"deployment"`
+	Path       string `json:"path"`
+}
+
+// recordAttempt updates the metrics of the subscription an invocation was
+// published for
+func (h *Handlers) recordAttempt(a queue.Attempt) {
+	id := a.Invocation.Subscription
+	if id == "" {
+		return
+	}
+	var err error
+	if a.Succeeded {
+		err = h.topics.RecordDelivered(context.Background(), id, a.Latency)
+	} else {
+		err = h.topics.RecordFailed(context.Background(), id, a.Dead, a.Err.Error())
+	}
+	if err != nil {
+		slog.Error("Error recording subscription delivery", "subscription", id, "error", err)
+	}
+}
+
+// topicsHandler serves the topics that functions can publish events to:
+//
+//	GET        /topics
+//	POST       /topics/{topic}/publish
+//	GET|POST   /topics/{topic}/subscriptions
+//	GET|DELETE /topics/{topic}/subscriptions/{id}
+func (h *Handlers) topicsHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/topics"), "/"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := h.topics.Topics(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving topics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	topic, sub, _ := strings.Cut(rest, "/")
+	if err := topics.ValidateName(topic); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resource, id, _ := strings.Cut(sub, "/")
+
+	switch {
+	case resource == "publish" && id == "":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.publishEvent(w, r, topic)
+
+	case resource == "subscriptions" && id == "":
+		h.topicSubscriptionsHandler(w, r, topic)
+
+	case resource == "subscriptions":
+		h.subscriptionHandler(w, r, topic, id)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// publishEvent queues an asynchronous invocation of every subscriber of a topic
+// with the request body
+func (h *Handlers) publishEvent(w http.ResponseWriter, r *http.Request, topic string) {
+	body, ok := readBody(w, r, maxInvocationBody)
+	if !ok {
+		return
+	}
+	subs, err := h.topics.Subscriptions(r.Context(), topic)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving subscriptions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	ev := event{ID: uuid.New().String(), Topic: topic, Invocations: []string{}}
+	invs := make([]queue.Invocation, len(subs))
+	ids := make([]string, len(subs))
+	for i, sub := range subs {
+		header := http.Header{}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			header.Set("Content-Type", ct)
+		}
+		header.Set("X-Topic", topic)
+		header.Set("X-Event-ID", ev.ID)
+		header.Set("X-Subscription-ID", sub.ID)
+		tracing.Inject(r.Context(), header)
+		invs[i] = queue.Invocation{
+			Deployment:   sub.Deployment,
+			Method:       http.MethodPost,
+			Path:         sub.Path,
+			Headers:      header,
+			Body:         body,
+			Subscription: sub.ID,
+		}
+		ids[i] = sub.ID
+	}
+	if len(invs) > 0 {
+		queued, err := h.queue.EnqueueAll(r.Context(), invs)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error queueing event: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, inv := range queued {
+			ev.Invocations = append(ev.Invocations, inv.ID)
+		}
+		if err := h.topics.RecordPublished(r.Context(), ids); err != nil {
+			slog.ErrorContext(r.Context(), "Error recording published event", "topic", topic, "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ev)
+}
+
+// topicSubscriptionsHandler lists the subscriptions of a topic or
+// subscribes a deployment to it
+func (h *Handlers) topicSubscriptionsHandler(w http.ResponseWriter, r *http.Request, topic string) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.topics.Subscriptions(r.Context(), topic)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving subscriptions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req subscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Deployment == "" {
+			http.Error(w, "deployment is required", http.StatusBadRequest)
+			return
+		}
+		deployment, err := h.store.Get(r.Context(), req.Deployment)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving deployment: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if deployment == nil {
+			http.Error(w, "Deployment not found", http.StatusNotFound)
+			return
+		}
+		sub, err := h.topics.Subscribe(r.Context(), topic, req.Deployment, req.Path)
+		if err == topics.ErrExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// subscriptionHandler gets or removes a subscription of a topic
+func (h *Handlers) subscriptionHandler(w http.ResponseWriter, r *http.Request, topic, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		sub, err := h.topics.Get(r.Context(), topic, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving subscription: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if sub == nil {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sub)
+
+	case http.MethodDelete:
+		found, err := h.topics.Unsubscribe(r.Context(), topic, id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error deleting subscription: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deploymentSubscriptionsHandler serves /deployments/{name}/subscriptions,
+// the topics the deployment is subscribed to with their metrics
+func (h *Handlers) deploymentSubscriptionsHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if arg != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	list, err := h.topics.ForDeployment(r.Context(), deployment.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving subscriptions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/envvars"
+	"main/types"
+	"main/webhooks"
+)
+
+// maxWebhookBody is the largest request body a webhook accepts
+const maxWebhookBody = 1 << 20
+
+// hooksHandler serves /hooks/{token}, the public URL of a webhook. The
+// request is checked against the webhook's signature, if it has one, and
+// forwarded to the function, cold starting it if it is stopped.
+func (h *Handlers) hooksHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/hooks/"), "/")
+	if token == "" || strings.Contains(token, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	wh, err := h.webhooks.GetByToken(r.Context(), token)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving webhook: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if wh == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	body, ok := readBody(w, r, maxWebhookBody)
+	if !ok {
+		return
+	}
+	delivery := &webhooks.Delivery{
+		WebhookID:  wh.ID,
+		ReceivedAt: time.Now().Format(time.RFC3339),
+		Method:     r.Method,
+		Headers:    wh.StoredHeaders(r.Header),
+		Query:      r.URL.RawQuery,
+		Body:       body,
+	}
+	if err := wh.Verify(body, r.Header); err != nil {
+		slog.WarnContext(r.Context(), "Rejected webhook delivery", "deployment", wh.Deployment, "webhook", wh.ID, "error", err)
+		delivery.Error = err.Error()
+		if err := h.webhooks.RecordDelivery(r.Context(), delivery); err != nil {
+			slog.ErrorContext(r.Context(), "Error recording webhook delivery", "deployment", wh.Deployment, "webhook", wh.ID, "error", err)
+		}
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	delivery.Verified = wh.Signed
+
+	h.deliver(r.Context(), wh, delivery, r.Header, w)
+}
+
+// deliver forwards a webhook delivery with header to the function and
+// records the outcome. If w is not nil the function's response is copied to
+// it.
+func (h *Handlers) deliver(ctx context.Context, wh *webhooks.Webhook, d *webhooks.Delivery, header http.Header, w http.ResponseWriter) {
+	started := time.Now()
+	resp, err := h.forwardDelivery(ctx, wh, d, header)
+	if err != nil {
+		d.Error = err.Error()
+		slog.WarnContext(ctx, "Webhook delivery failed", "deployment", wh.Deployment, "webhook", wh.ID, "error", err)
+		if w != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, errNotBuilt) {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, fmt.Sprintf("Error delivering webhook: %v", err), status)
+		}
+	} else {
+		d.StatusCode = resp.StatusCode
+		snippet := &limitedBuffer{max: webhooks.MaxResponse}
+		var out io.Writer = snippet
+		if w != nil {
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.StatusCode)
+			out = io.MultiWriter(w, snippet)
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			d.Error = fmt.Sprintf("error reading response: %v", err)
+		}
+		resp.Body.Close()
+		d.Response = snippet.String()
+	}
+	d.LatencyMs = time.Since(started).Milliseconds()
+
+	// The delivery is recorded even if the sender has gone meanwhile
+	if err := h.webhooks.RecordDelivery(context.WithoutCancel(ctx), d); err != nil {
+		slog.ErrorContext(ctx, "Error recording webhook delivery", "deployment", wh.Deployment, "webhook", wh.ID, "error", err)
+	}
+}
+
+// forwardDelivery sends a delivery's request with header to the webhook's
+// path on the function
+func (h *Handlers) forwardDelivery(ctx context.Context, wh *webhooks.Webhook, d *webhooks.Delivery, header http.Header) (*http.Response, error) {
+	header = header.Clone()
+	header.Set("X-Webhook-ID", wh.ID)
+	if d.ReplayOf != nil {
+		header.Set("X-Webhook-Replay-Of", strconv.FormatInt(*d.ReplayOf, 10))
+	}
+	path := wh.Path
+	if d.Query != "" {
+		path += "?" + d.Query
+	}
+	return h.callFunction(ctx, wh.Deployment, d.Method, path, header, d.Body)
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the
+// rest
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// webhookRequest is the body of POST /deployments/{name}/webhooks
+type webhookRequest struct {
+	Path string `json:"path"`
+	// Secret signs requests to the webhook. GenerateSecret creates a random
+	// one, which is returned once in the response.
+	Secret          string `json:"secret"`
+	GenerateSecret  bool   `json:"generateSecret"`
+	SignatureHeader string `json:"signatureHeader"`
+}
+
+// webhookResponse adds the webhook's URL to a webhook, and its secret
+// right after it was created
+type webhookResponse struct {
+	*webhooks.Webhook
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+func newWebhookResponse(wh *webhooks.Webhook) webhookResponse {
+	return webhookResponse{Webhook: wh, URL: "/hooks/" + wh.Token}
+}
+
+// deliveryResponse is a delivery with its body
+type deliveryResponse struct {
+	*webhooks.Delivery
+	Body string `json:"body"`
+}
+
+// webhooksHandler serves /deployments/{name}/webhooks:
+//
+//	GET|POST   /deployments/{name}/webhooks
+//	GET|DELETE /deployments/{name}/webhooks/{id}
+//	GET        /deployments/{name}/webhooks/{id}/deliveries
+//	GET        /deployments/{name}/webhooks/{id}/deliveries/{delivery}
+//	POST       /deployments/{name}/webhooks/{id}/deliveries/{delivery}/replay
+func (h *Handlers) webhooksHandler(w http.ResponseWriter, r *http.Request, deployment *types.Deployment, arg string) {
+	id, sub, _ := strings.Cut(arg, "/")
+	name := deployment.Name
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := h.webhooks.List(r.Context(), name)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error retrieving webhooks: %v", err), http.StatusInternalServerError)
+				return
+			}
+			resp := make([]webhookResponse, len(list))
+			for i := range list {
+				resp[i] = newWebhookResponse(&list[i])
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+
+		case http.MethodPost:
+			var req webhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := webhooks.Validate(&webhooks.Webhook{Path: req.Path}); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			secret := req.Secret
+			if req.GenerateSecret {
+				if secret != "" {
+					http.Error(w, "secret and generateSecret are mutually exclusive", http.StatusBadRequest)
+					return
+				}
+				s, err := webhooks.GenerateSecret()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				secret = s
+			}
+			wh, err := h.webhooks.Create(r.Context(), webhooks.Webhook{
+				Deployment:      name,
+				Path:            req.Path,
+				SignatureHeader: req.SignatureHeader,
+			}, secret)
+			if errors.Is(err, envvars.ErrNoKey) {
+				http.Error(w, "Signed webhooks are disabled: no secrets key configured", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error creating webhook: %v", err), http.StatusInternalServerError)
+				return
+			}
+			resp := newWebhookResponse(wh)
+			resp.Secret = secret
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(resp)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	wh, err := h.webhooks.Get(r.Context(), name, id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving webhook: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if wh == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if sub == "" {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newWebhookResponse(wh))
+		case http.MethodDelete:
+			if _, err := h.webhooks.Delete(r.Context(), name, id); err != nil {
+				http.Error(w, fmt.Sprintf("Error deleting webhook: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	sub, rest, _ := strings.Cut(sub, "/")
+	if sub != "deliveries" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	h.deliveriesHandler(w, r, wh, rest)
+}
+
+// deliveriesHandler serves the deliveries of a webhook
+func (h *Handlers) deliveriesHandler(w http.ResponseWriter, r *http.Request, wh *webhooks.Webhook, arg string) {
+	id, action, _ := strings.Cut(arg, "/")
+
+	if id == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, ok := limitParam(w, r)
+		if !ok {
+			return
+		}
+		list, err := h.webhooks.Deliveries(r.Context(), wh.ID, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error retrieving deliveries: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	delivery, err := h.webhooks.Delivery(r.Context(), wh.ID, deliveryID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving delivery: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveryResponse{Delivery: delivery, Body: string(delivery.Body)})
+
+	case action == "replay" && r.Method == http.MethodPost:
+		// Rejected deliveries were never verified, so replaying them would
+		// bypass the signature check
+		if wh.Signed && !delivery.Verified {
+			http.Error(w, "Delivery was rejected and cannot be replayed", http.StatusConflict)
+			return
+		}
+		replay := &webhooks.Delivery{
+			WebhookID:  wh.ID,
+			ReceivedAt: time.Now().Format(time.RFC3339),
+			Method:     delivery.Method,
+			Headers:    delivery.Headers,
+			Query:      delivery.Query,
+			Body:       delivery.Body,
+			Verified:   delivery.Verified,
+			ReplayOf:   &delivery.ID,
+		}
+		h.deliver(r.Context(), wh, replay, replay.Headers, nil)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveryResponse{Delivery: replay, Body: string(replay.Body)})
+
+	case action == "" || action == "replay":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
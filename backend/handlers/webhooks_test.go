@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"main/webhooks"
+)
+
+// failingReader fails like a connection dropped while sending the body
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestWebhookBodyErrors(t *testing.T) {
+	s := newTestServer(t)
+	wh, err := s.h.webhooks.Create(context.Background(), webhooks.Webhook{Deployment: "hello"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		body io.Reader
+		code int
+	}{
+		{bytes.NewReader(make([]byte, maxWebhookBody+1)), http.StatusRequestEntityTooLarge},
+		{failingReader{}, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hooks/"+wh.Token, tt.body))
+		if rec.Code != tt.code {
+			t.Errorf("got %d %q, want %d", rec.Code, rec.Body.String(), tt.code)
+		}
+	}
+}
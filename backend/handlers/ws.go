@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"main/logs"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket channels a client can subscribe to per deployment
+const (
+	channelStatus      = "status"
+	channelBuildLogs   = "build_logs"
+	channelRuntimeLogs = "runtime_logs"
+)
+
+// allDeployments subscribes to a channel for every deployment
+const allDeployments = "*"
+
+var wsChannels = map[string]bool{
+	channelStatus:      true,
+	channelBuildLogs:   true,
+	channelRuntimeLogs: true,
+}
+
+// wsRequest is a message sent by a client over /ws:
+//
+//	{"action": "subscribe", "deployment": "hello", "channels": ["status", "runtime_logs"]}
+//	{"action": "unsubscribe", "deployment": "hello", "channels": ["runtime_logs"]}
+//
+// Channels default to ["status"] and deployment "*" matches every deployment.
+type wsRequest struct {
+	Action     string   `json:"action"`
+	Deployment string   `json:"deployment"`
+	Channels   []string `json:"channels"`
+}
+
+// wsClient is a connected WebSocket client and the topics it subscribed to
+type wsClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	subsMu sync.Mutex
+	// subs maps a deployment name (or "*") to its subscribed channels
+	subs map[string]map[string]bool
+}
+
+func newWSClient(conn *websocket.Conn) *wsClient {
+	// New clients receive status events for every deployment, which is what
+	// the dashboard relies on, until they unsubscribe
+	return &wsClient{
+		conn: conn,
+		subs: map[string]map[string]bool{
+			allDeployments: {channelStatus: true},
+		},
+	}
+}
+
+func (c *wsClient) subscribed(deployment, channel string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return c.subs[deployment][channel] || c.subs[allDeployments][channel]
+}
+
+func (c *wsClient) subscribe(deployment string, channels []string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.subs[deployment] == nil {
+		c.subs[deployment] = make(map[string]bool)
+	}
+	for _, ch := range channels {
+		c.subs[deployment][ch] = true
+	}
+}
+
+func (c *wsClient) unsubscribe(deployment string, channels []string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	for _, ch := range channels {
+		delete(c.subs[deployment], ch)
+	}
+	if len(c.subs[deployment]) == 0 {
+		delete(c.subs, deployment)
+	}
+}
+
+func (c *wsClient) write(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (c *wsClient) writeJSON(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(msg)
+}
+
+// broadcastMessage sends a status event about a deployment to the clients
+// subscribed to its status channel
+func (h *Handlers) broadcastMessage(deployment string, message interface{}) {
+	h.publish(deployment, channelStatus, message)
+}
+
+// publish sends a message to every client subscribed to the channel of the
+// deployment, dropping clients that can no longer be written to
+func (h *Handlers) publish(deployment, channel string, message interface{}) {
+	msg, err := json.Marshal(message)
+	if err != nil {
+		slog.Error("Error marshaling message", "deployment", deployment, "error", err)
+		return
+	}
+
+	h.clientsMux.Lock()
+	var targets []*wsClient
+	for client := range h.clients {
+		if client.subscribed(deployment, channel) {
+			targets = append(targets, client)
+		}
+	}
+	h.clientsMux.Unlock()
+
+	for _, client := range targets {
+		if err := client.write(msg); err != nil {
+			slog.Warn("Error sending message to client", "deployment", deployment, "error", err)
+			client.conn.Close()
+			h.clientsMux.Lock()
+			delete(h.clients, client)
+			h.clientsMux.Unlock()
+		}
+	}
+}
+
+// forwardLogs publishes captured build and run output to the clients
+// subscribed to the deployment's log channels
+func (h *Handlers) forwardLogs() {
+	lines, _ := h.logs.Subscribe("")
+	for l := range lines {
+		channel := channelRuntimeLogs
+		if l.Kind == logs.Build {
+			channel = channelBuildLogs
+		}
+		h.publish(l.Deployment, channel, map[string]interface{}{
+			"type":       "log",
+			"channel":    channel,
+			"deployment": l.Deployment,
+			"data":       l,
+		})
+	}
+}
+
+func (h *Handlers) wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error upgrading connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	client := newWSClient(conn)
+	h.clientsMux.Lock()
+	h.clients[client] = true
+	h.clientsMux.Unlock()
+
+	defer func() {
+		h.clientsMux.Lock()
+		delete(h.clients, client)
+		h.clientsMux.Unlock()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		h.handleWSRequest(client, data)
+	}
+}
+
+// handleWSRequest applies a subscribe or unsubscribe request and
+// acknowledges it, or replies with an error message
+func (h *Handlers) handleWSRequest(client *wsClient, data []byte) {
+	reply := func(v map[string]interface{}) {
+		if err := client.writeJSON(v); err != nil {
+			slog.Warn("Error sending message to client", "error", err)
+		}
+	}
+	fail := func(msg string) {
+		reply(map[string]interface{}{"type": "error", "error": msg})
+	}
+
+	var req wsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		fail("invalid message")
+		return
+	}
+	if req.Action == "ping" {
+		reply(map[string]interface{}{"type": "pong"})
+		return
+	}
+	if req.Deployment == "" {
+		fail("deployment is required")
+		return
+	}
+	if len(req.Channels) == 0 {
+		req.Channels = []string{channelStatus}
+	}
+	for _, ch := range req.Channels {
+		if !wsChannels[ch] {
+			fail("unknown channel: " + ch)
+			return
+		}
+	}
+
+	switch req.Action {
+	case "subscribe":
+		client.subscribe(req.Deployment, req.Channels)
+		reply(map[string]interface{}{"type": "subscribed", "deployment": req.Deployment, "channels": req.Channels})
+	case "unsubscribe":
+		client.unsubscribe(req.Deployment, req.Channels)
+		reply(map[string]interface{}{"type": "unsubscribed", "deployment": req.Deployment, "channels": req.Channels})
+	default:
+		fail("unknown action: " + req.Action)
+	}
+}
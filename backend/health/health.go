@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"main/config"
+	"main/types"
+)
+
+// Health check types
+const (
+	TypeHTTP = "http"
+	TypeTCP  = "tcp"
+	TypeNone = "none"
+)
+
+// Restart policies
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// Resolve returns the deployment's health check and restart policy with
+// the configured defaults filled in
+func Resolve(d *types.Deployment, cfg *config.Config) (types.HealthCheck, string) {
+	check := d.HealthCheck
+	if check.Type == "" {
+		check.Type = cfg.Health.CheckType
+	}
+	if check.Path == "" {
+		check.Path = cfg.Health.CheckPath
+	}
+	if check.IntervalSeconds == 0 {
+		check.IntervalSeconds = seconds(cfg.Health.CheckInterval)
+	}
+	if check.TimeoutSeconds == 0 {
+		check.TimeoutSeconds = seconds(cfg.Health.CheckTimeout)
+	}
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = cfg.Health.FailureThreshold
+	}
+	policy := d.RestartPolicy
+	if policy == "" {
+		policy = cfg.Health.RestartPolicy
+	}
+	return check, policy
+}
+
+func seconds(d time.Duration) int {
+	if s := int(d / time.Second); s > 0 {
+		return s
+	}
+	return 1
+}
+
+// Validate checks a deployment's health check and restart policy. Empty
+// and zero values are allowed and mean the default.
+func Validate(check types.HealthCheck, policy string) error {
+	switch check.Type {
+	case "", TypeHTTP, TypeTCP, TypeNone:
+	default:
+		return fmt.Errorf("health check type must be %s, %s or %s", TypeHTTP, TypeTCP, TypeNone)
+	}
+	if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+		return errors.New("health check path must start with /")
+	}
+	if check.IntervalSeconds < 0 || check.TimeoutSeconds < 0 || check.FailureThreshold < 0 {
+		return errors.New("health check interval, timeout and failure threshold must not be negative")
+	}
+	switch policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("restart policy must be %s, %s or %s", RestartNever, RestartOnFailure, RestartAlways)
+	}
+	return nil
+}
+
+// probeClient doesn't follow redirects, so that a 3xx response passes the
+// check itself rather than whatever it points to
+var probeClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Probe checks a function listening on the local port once
+func Probe(ctx context.Context, port string, check types.HealthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(check.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	switch check.Type {
+	case TypeNone:
+		return nil
+	case TypeHTTP:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:"+port+check.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := probeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s returned %s", check.Path, resp.Status)
+		}
+		return nil
+	default:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", "localhost:"+port)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	}
+}
+
+// ShouldRestart reports whether a function that exited should be restarted
+// under policy. failed is false if it exited with status 0.
+func ShouldRestart(policy string, failed bool) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failed
+	default:
+		return false
+	}
+}
+
+// Backoff returns the delay before retry number n, counting from 1, such as
+// a restart or another delivery attempt. It doubles from initial up to max.
+func Backoff(n int, initial, max time.Duration) time.Duration {
+	delay := initial
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"main/types"
+)
+
+func TestProbe(t *testing.T) {
+	fn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/login":
+			// Passes although following it would fail
+			http.Redirect(w, r, "/missing", http.StatusFound)
+		case "/broken":
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer fn.Close()
+	u, err := url.Parse(fn.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := u.Port()
+
+	for _, tt := range []struct {
+		check types.HealthCheck
+		pass  bool
+	}{
+		{types.HealthCheck{Type: TypeHTTP, Path: "/healthz"}, true},
+		{types.HealthCheck{Type: TypeHTTP, Path: "/login"}, true},
+		{types.HealthCheck{Type: TypeHTTP, Path: "/missing"}, false},
+		{types.HealthCheck{Type: TypeHTTP, Path: "/broken"}, false},
+		{types.HealthCheck{Type: TypeTCP}, true},
+		{types.HealthCheck{Type: TypeNone}, true},
+	} {
+		tt.check.TimeoutSeconds = 5
+		err := Probe(context.Background(), port, tt.check)
+		if tt.pass && err != nil {
+			t.Errorf("%s check %s: %v, want it to pass", tt.check.Type, tt.check.Path, err)
+		}
+		if !tt.pass && err == nil {
+			t.Errorf("%s check %s passed, want it to fail", tt.check.Type, tt.check.Path)
+		}
+	}
+}
+
+func TestProbeNotListening(t *testing.T) {
+	fn := httptest.NewServer(http.NotFoundHandler())
+	u, err := url.Parse(fn.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn.Close()
+
+	for _, typ := range []string{TypeHTTP, TypeTCP} {
+		if err := Probe(context.Background(), u.Port(), types.HealthCheck{Type: typ, Path: "/", TimeoutSeconds: 5}); err == nil {
+			t.Errorf("%s check of a closed port passed, want it to fail", typ)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+
+	"main/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// maxOutput is how much of a job's output is kept, from the end
+const maxOutput = 64 << 10
+
+// ErrFinished is returned when cancelling a job that has already finished
+var ErrFinished = errors.New("job has already finished")
+
+// ErrShuttingDown is returned when starting a job after Shutdown
+var ErrShuttingDown = errors.New("backend is shutting down")
+
+// Job is a long running operation on a deployment
+type Job struct {
+	ID         string `json:"id"`
+	Deployment string `json:"deployment"`
+	Kind       string `json:"kind"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	Output     string `json:"output"`
+	CreatedAt  string `json:"createdAt"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+}
+
+// Run is the handle a job's function uses to report progress
+type Run struct {
+	// Output is stored with the job
+	Output io.Writer
+
+	m          *Manager
+	id         string
+	kind       string
+	deployment string
+	out        *tailBuffer
+	cancel     context.CancelFunc
+}
+
+// Start marks a queued job as running. ctx is the one passed to the job's
+// function.
+func (r *Run) Start(ctx context.Context) {
+	_, err := r.m.db.ExecContext(context.WithoutCancel(ctx),
+		"UPDATE jobs SET status = ?, started_at = ? WHERE id = ?",
+		StatusRunning, time.Now().Format(time.RFC3339), r.id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating job", "job", r.id, "error", err)
+	}
+}
+
+// Manager runs jobs in the background and keeps their records in SQLite
+type Manager struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	running map[string]*Run
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewManager returns a manager backed by the jobs table. Jobs left queued
+// or running by a previous backend are marked failed.
+func NewManager(db *sql.DB) (*Manager, error) {
+	_, err := db.Exec(`
+		UPDATE jobs SET status = ?, error = ?, finished_at = ?
+		WHERE status IN (?, ?)
+	`, StatusFailed, "interrupted by a backend restart", time.Now().Format(time.RFC3339), StatusQueued, StatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("error failing interrupted jobs: %v", err)
+	}
+	return &Manager{db: db, running: make(map[string]*Run)}, nil
+}
+
+// Run records a new job and calls fn in the background. The context passed
+// to fn carries the values of ctx, such as the request ID, and a span for
+// the job in the trace of ctx, but is only cancelled by Cancel and
+// Shutdown. A queued job stays queued until fn calls Start; otherwise it is
+// running from the start. Run returns ErrShuttingDown after Shutdown.
+func (m *Manager) Run(ctx context.Context, name, kind string, queued bool, fn func(ctx context.Context, run *Run) error) (*Job, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	now := time.Now().Format(time.RFC3339)
+	job := &Job{
+		ID:         uuid.New().String(),
+		Deployment: name,
+		Kind:       kind,
+		Status:     StatusRunning,
+		CreatedAt:  now,
+		StartedAt:  now,
+	}
+	if queued {
+		job.Status = StatusQueued
+		job.StartedAt = ""
+	}
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO jobs (id, deployment_name, kind, status, created_at, started_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Deployment, job.Kind, job.Status, job.CreatedAt, job.StartedAt)
+	if err != nil {
+		m.wg.Done()
+		return nil, fmt.Errorf("error creating job: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	ctx, span := tracing.Start(ctx, "job "+kind, trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.String("job.kind", kind),
+		attribute.String("deployment", name),
+	))
+	out := &tailBuffer{max: maxOutput}
+	run := &Run{Output: out, m: m, id: job.ID, out: out, cancel: cancel, kind: kind, deployment: name}
+	slog.InfoContext(ctx, "Job started", "job", job.ID, "kind", kind, "deployment", name, "status", job.Status)
+	m.mu.Lock()
+	m.running[job.ID] = run
+	if m.closed {
+		// Shutdown started while the job was being recorded
+		cancel()
+	}
+	m.mu.Unlock()
+
+	go func() {
+		defer m.wg.Done()
+		err := fn(ctx, run)
+		m.finish(ctx, run, err)
+		tracing.End(span, err)
+		cancel()
+	}()
+	return job, nil
+}
+
+func (m *Manager) finish(ctx context.Context, run *Run, err error) {
+	status, message := StatusSucceeded, ""
+	var exitCode *int
+	if err != nil {
+		status, message = StatusFailed, err.Error()
+		if ctx.Err() != nil {
+			status = StatusCancelled
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code := exitErr.ExitCode()
+			exitCode = &code
+		}
+	}
+
+	// The outcome is recorded even if the job was cancelled
+	_, dbErr := m.db.ExecContext(context.WithoutCancel(ctx), `
+		UPDATE jobs SET status = ?, error = ?, exit_code = ?, output = ?, finished_at = ?
+		WHERE id = ?
+	`, status, message, exitCode, run.out.String(), time.Now().Format(time.RFC3339), run.id)
+	if dbErr != nil {
+		slog.ErrorContext(ctx, "Error updating job", "job", run.id, "error", dbErr)
+	}
+	level := slog.LevelInfo
+	if status == StatusFailed {
+		level = slog.LevelWarn
+	}
+	attrs := []interface{}{"job", run.id, "kind", run.kind, "deployment", run.deployment, "status", status}
+	if message != "" {
+		attrs = append(attrs, "error", message)
+	}
+	slog.Log(ctx, level, "Job finished", attrs...)
+
+	m.mu.Lock()
+	delete(m.running, run.id)
+	m.mu.Unlock()
+}
+
+// Cancel cancels a queued or running job. It returns ErrFinished if the
+// job has already finished, and nil, nil if it does not exist.
+func (m *Manager) Cancel(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	run, ok := m.running[id]
+	m.mu.Unlock()
+	if ok {
+		run.cancel()
+	}
+
+	job, err := m.Get(ctx, id)
+	if err != nil || job == nil {
+		return job, err
+	}
+	if !ok {
+		return job, ErrFinished
+	}
+	return job, nil
+}
+
+// Shutdown stops new jobs from starting, cancels the queued and running
+// ones and waits until they have finished or ctx is done
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	for _, run := range m.running {
+		run.cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for jobs: %v", ctx.Err())
+	}
+}
+
+// Get retrieves a job, including the output so far of a running job, or
+// nil if it does not exist
+func (m *Manager) Get(ctx context.Context, id string) (*Job, error) {
+	job, err := scanJob(m.db.QueryRowContext(ctx, `
+		SELECT id, deployment_name, kind, status, error, exit_code, output, created_at, started_at, finished_at
+		FROM jobs
+		WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting job: %v", err)
+	}
+
+	m.mu.Lock()
+	run, ok := m.running[id]
+	m.mu.Unlock()
+	if ok {
+		job.Output = run.out.String()
+	}
+	return job, nil
+}
+
+// List returns the most recent jobs of a deployment, newest first, without
+// their output
+func (m *Manager) List(ctx context.Context, name string, limit int) ([]Job, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, deployment_name, kind, status, error, exit_code, '', created_at, started_at, finished_at
+		FROM jobs
+		WHERE deployment_name = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ?
+	`, name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning job: %v", err)
+		}
+		jobs = append(jobs, *job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+// DeleteAll removes the job history of a deployment
+func (m *Manager) DeleteAll(ctx context.Context, name string) error {
+	if _, err := m.db.ExecContext(ctx, "DELETE FROM jobs WHERE deployment_name = ?", name); err != nil {
+		return fmt.Errorf("error deleting jobs: %v", err)
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var j Job
+	var exitCode sql.NullInt64
+	err := row.Scan(&j.ID, &j.Deployment, &j.Kind, &j.Status, &j.Error, &exitCode, &j.Output,
+		&j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		j.ExitCode = &code
+	}
+	return &j, nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"main/db"
+	"main/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestJobStatementSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	conn, err := db.InitDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	m, err := NewManager(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, request := tracing.Start(context.Background(), "POST /build/{name}")
+	job, err := m.Run(ctx, "hello", "build", true, func(ctx context.Context, run *Run) error {
+		run.Start(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.End()
+
+	// The job's span ends once the job is finished
+	var jobSpan sdktrace.ReadOnlySpan
+	for deadline := time.Now().Add(10 * time.Second); jobSpan == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no job span within 10s")
+		}
+		for _, span := range recorder.Ended() {
+			if span.Name() == "job build" {
+				jobSpan = span
+			}
+		}
+	}
+	if job, err = m.Get(context.Background(), job.ID); err != nil || job.Status != StatusSucceeded {
+		t.Fatalf("got job %+v, error %v", job, err)
+	}
+
+	// The insert belongs to the request, starting and finishing to the job
+	parents := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		if span.Name() != "db.exec" {
+			continue
+		}
+		switch span.Parent().SpanID() {
+		case request.SpanContext().SpanID():
+			parents["request"]++
+		case jobSpan.SpanContext().SpanID():
+			parents["job"]++
+		default:
+			t.Errorf("statement span with unexpected parent %s", span.Parent().SpanID())
+		}
+	}
+	if parents["request"] != 1 || parents["job"] != 2 {
+		t.Fatalf("got %d statements of the request and %d of the job, want 1 and 2", parents["request"], parents["job"])
+	}
+}
@@ -0,0 +1,342 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind distinguishes build output from runtime output
+type Kind string
+
+const (
+	Build Kind = "build"
+	Run   Kind = "run"
+)
+
+// attemptFormat names attempts so that they sort chronologically
+const attemptFormat = "20060102T150405.000000000Z"
+
+// Line is a single line of captured output
+type Line struct {
+	Deployment string    `json:"deployment"`
+	Kind       Kind      `json:"kind"`
+	Attempt    string    `json:"attempt"`
+	Time       time.Time `json:"time"`
+	Text       string    `json:"text"`
+}
+
+// Attempt describes the log of one build or run
+type Attempt struct {
+	Kind    Kind      `json:"kind"`
+	ID      string    `json:"id"`
+	Started time.Time `json:"started"`
+	Size    int64     `json:"size"`
+}
+
+// Query selects lines from a deployment's logs
+type Query struct {
+	// Kind limits the result to build or run logs. Empty means both.
+	Kind Kind
+	// Attempt selects a specific attempt. Empty means the latest attempt of
+	// each kind.
+	Attempt string
+	// Tail keeps only the last Tail lines when positive
+	Tail int
+	// Since drops lines written before it when non-zero
+	Since time.Time
+}
+
+// Store writes build and run output to DataDir/.logs/<name>/<kind>-<attempt>.log.
+// Each attempt file is rotated to a single .1 backup when it grows beyond
+// maxSize, and only the newest maxAttempts attempts of each kind are kept.
+type Store struct {
+	dir         string
+	maxAttempts int
+	maxSize     int64
+
+	mu   sync.Mutex
+	subs map[chan Line]string
+}
+
+func NewStore(dir string, maxAttempts int, maxSize int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %v", err)
+	}
+	return &Store{
+		dir:         dir,
+		maxAttempts: maxAttempts,
+		maxSize:     maxSize,
+		subs:        make(map[chan Line]string),
+	}, nil
+}
+
+// Open starts a new attempt and returns a writer for its output
+func (s *Store) Open(name string, kind Kind) (*Writer, error) {
+	dir := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %v", err)
+	}
+	attempt := time.Now().UTC().Format(attemptFormat)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.log", kind, attempt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file: %v", err)
+	}
+	s.prune(name, kind)
+	return &Writer{store: s, name: name, kind: kind, attempt: attempt, path: path, file: f}, nil
+}
+
+// Attempts lists the attempts of a deployment, oldest first. An empty kind
+// lists both kinds.
+func (s *Store) Attempts(name string, kind Kind) ([]Attempt, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return []Attempt{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading log directory: %v", err)
+	}
+
+	attempts := []Attempt{}
+	for _, e := range entries {
+		base, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok {
+			continue
+		}
+		k, id, ok := strings.Cut(base, "-")
+		if !ok || (kind != "" && Kind(k) != kind) {
+			continue
+		}
+		started, err := time.Parse(attemptFormat, id)
+		if err != nil {
+			continue
+		}
+		a := Attempt{Kind: Kind(k), ID: id, Started: started}
+		if info, err := e.Info(); err == nil {
+			a.Size = info.Size()
+		}
+		if info, err := os.Stat(filepath.Join(s.dir, name, e.Name()+".1")); err == nil {
+			a.Size += info.Size()
+		}
+		attempts = append(attempts, a)
+	}
+	sort.Slice(attempts, func(i, j int) bool { return attempts[i].ID < attempts[j].ID })
+	return attempts, nil
+}
+
+// Read returns the lines matching the query in the order they were written
+func (s *Store) Read(name string, q Query) ([]Line, error) {
+	attempts, err := s.Attempts(name, q.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pick the requested attempt, or the latest of each kind
+	selected := map[Kind]Attempt{}
+	for _, a := range attempts {
+		if q.Attempt == "" || a.ID == q.Attempt {
+			selected[a.Kind] = a
+		}
+	}
+
+	lines := []Line{}
+	for _, a := range selected {
+		path := filepath.Join(s.dir, name, fmt.Sprintf("%s-%s.log", a.Kind, a.ID))
+		for _, p := range []string{path + ".1", path} {
+			read, err := readFile(p, name, a)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, read...)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Time.Before(lines[j].Time) })
+
+	if !q.Since.IsZero() {
+		i := sort.Search(len(lines), func(i int) bool { return !lines[i].Time.Before(q.Since) })
+		lines = lines[i:]
+	}
+	if q.Tail > 0 && len(lines) > q.Tail {
+		lines = lines[len(lines)-q.Tail:]
+	}
+	return lines, nil
+}
+
+// Subscribe returns a channel receiving every new line of a deployment, or
+// of all deployments when name is empty. Lines are dropped for subscribers
+// that fall behind. The returned func cancels the subscription.
+func (s *Store) Subscribe(name string) (<-chan Line, func()) {
+	ch := make(chan Line, 256)
+	s.mu.Lock()
+	s.subs[ch] = name
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Delete removes all logs of a deployment
+func (s *Store) Delete(name string) error {
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("error deleting logs: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) publish(l Line) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch, name := range s.subs {
+		if name != "" && name != l.Deployment {
+			continue
+		}
+		select {
+		case ch <- l:
+		default:
+		}
+	}
+}
+
+// prune removes the oldest attempts of a kind beyond maxAttempts
+func (s *Store) prune(name string, kind Kind) {
+	if s.maxAttempts <= 0 {
+		return
+	}
+	attempts, err := s.Attempts(name, kind)
+	if err != nil || len(attempts) <= s.maxAttempts {
+		return
+	}
+	for _, a := range attempts[:len(attempts)-s.maxAttempts] {
+		path := filepath.Join(s.dir, name, fmt.Sprintf("%s-%s.log", a.Kind, a.ID))
+		os.Remove(path)
+		os.Remove(path + ".1")
+	}
+}
+
+func readFile(path, name string, a Attempt) ([]Line, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file: %v", err)
+	}
+	defer f.Close()
+
+	var lines []Line
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ts, text, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, Line{Deployment: name, Kind: a.Kind, Attempt: a.ID, Time: t, Text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading log file: %v", err)
+	}
+	return lines, nil
+}
+
+// Writer captures the output of one attempt. Output is split into lines,
+// each stored with the time it was written.
+type Writer struct {
+	store   *Store
+	name    string
+	kind    Kind
+	attempt string
+	path    string
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	partial []byte
+}
+
+// Attempt returns the ID of the attempt being written
+func (w *Writer) Attempt() string {
+	return w.attempt
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimRight(w.partial[:i], "\r"))
+		w.partial = w.partial[i+1:]
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes any unterminated line and closes the file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.writeLine(string(bytes.TrimRight(w.partial, "\r")))
+		w.partial = nil
+	}
+	return w.file.Close()
+}
+
+func (w *Writer) writeLine(text string) error {
+	now := time.Now().UTC()
+	entry := now.Format(time.RFC3339Nano) + "\t" + text + "\n"
+
+	if w.store.maxSize > 0 && w.size+int64(len(entry)) > w.store.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.WriteString(entry)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing log: %v", err)
+	}
+
+	w.store.publish(Line{Deployment: w.name, Kind: w.kind, Attempt: w.attempt, Time: now, Text: text})
+	return nil
+}
+
+// rotate moves the current file to the .1 backup, replacing any previous
+// backup, and starts a new file
+func (w *Writer) rotate() error {
+	w.file.Close()
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return fmt.Errorf("error rotating log: %v", err)
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	w.file = f
+	w.size = 0
+	return nil
+}
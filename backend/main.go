@@ -1,27 +1,150 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"main/auth"
 	"main/config"
 	"main/db"
+	"main/envvars"
 	"main/handlers"
+	"main/jobs"
+	"main/logging"
+	"main/logs"
+	"main/metrics"
 	"main/middleware"
+	"main/ports"
+	"main/queue"
+	"main/revisions"
+	"main/runtime"
+	"main/schedules"
+	"main/topics"
+	"main/tracing"
+	"main/webhooks"
 )
 
 func main() {
-	// Load configuration
-	cfg := config.DefaultConfig()
+	// Load configuration from the config file, environment and flags
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		log.Fatal(err)
+	}
+
+	// Handle maintenance commands that do not start the server
+	if len(args) > 0 {
+		if err := runCommand(cfg, args[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := tracing.Setup(cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio); err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
 
 	// Initialize database
-	if err := db.InitDB(); err != nil {
+	conn, err := db.InitDB(cfg.Function.DataDir)
+	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Select the function runtime
+	rt, err := runtime.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize function runtime: %v", err)
+	}
+
+	// Set up authentication
+	authStore := auth.NewStore(conn)
+	password, err := authStore.Bootstrap(cfg.Auth.AdminUsername, cfg.Auth.AdminPassword)
+	if err != nil {
+		log.Fatalf("Failed to create initial user: %v", err)
+	}
+	if password != "" && cfg.Auth.AdminPassword == "" {
+		slog.Info("Created initial user", "username", cfg.Auth.AdminUsername, "password", password)
+	}
+	secret := []byte(cfg.Auth.SessionSecret)
+	if len(secret) == 0 {
+		slog.Warn("No session secret configured, sessions will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate session secret: %v", err)
+		}
+	}
+	authn := auth.NewAuthenticator(authStore, secret, cfg.Auth.SessionTTL)
+
+	envVars, err := envvars.NewStore(conn, cfg.Secrets.Key)
+	if err != nil {
+		log.Fatalf("Failed to initialize environment store: %v", err)
+	}
+
+	logStore, err := logs.NewStore(filepath.Join(cfg.Function.DataDir, ".logs"),
+		cfg.Logs.MaxAttempts, cfg.Logs.MaxFileSize)
+	if err != nil {
+		log.Fatalf("Failed to initialize log store: %v", err)
+	}
+
+	revStore, err := revisions.NewStore(conn, filepath.Join(cfg.Function.DataDir, ".revisions"))
+	if err != nil {
+		log.Fatalf("Failed to initialize revision store: %v", err)
+	}
+
+	jobManager, err := jobs.NewManager(conn)
+	if err != nil {
+		log.Fatalf("Failed to initialize job manager: %v", err)
+	}
+
+	portAllocator, err := ports.NewAllocator(conn, cfg.Ports.Min, cfg.Ports.Max)
+	if err != nil {
+		log.Fatalf("Failed to initialize port allocator: %v", err)
+	}
+
+	scheduler := schedules.NewScheduler(conn, cfg.Schedules.Timeout)
+
+	invocationQueue, err := queue.NewQueue(conn, cfg.Queue.MaxAttempts,
+		cfg.Queue.RetryBackoff, cfg.Queue.MaxRetryBackoff, cfg.Queue.Timeout)
+	if err != nil {
+		log.Fatalf("Failed to initialize invocation queue: %v", err)
+	}
+
+	m := metrics.New()
+
 	// Create handlers
-	h := handlers.NewHandlers(cfg, db.DB)
+	h := handlers.NewHandlers(cfg, db.NewSQLiteStore(conn), rt, authn, envVars, logStore, revStore, jobManager, portAllocator, scheduler, webhooks.NewStore(conn, envVars), invocationQueue, topics.NewStore(conn), m)
+
+	// Correct the state left behind by a previous run
+	if err := h.Reconcile(context.Background()); err != nil {
+		slog.Error("Error reconciling deployments", "error", err)
+	}
+
+	// Invoke functions on their schedules
+	if err := h.StartSchedules(); err != nil {
+		log.Fatalf("Failed to start schedules: %v", err)
+	}
+
+	// Deliver asynchronous invocations
+	h.StartQueue()
+
+	// Stop functions that have gone idle
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	go h.RunIdleReaper(reaperCtx)
 
 	// Create a new mux
 	mux := http.NewServeMux()
@@ -30,10 +153,119 @@ func main() {
 	h.RegisterRoutes(mux)
 
 	// Wrap the mux with middleware
-	handler := middleware.CORS(middleware.Logging(mux))
+	handler := middleware.CORS(cfg.Server.AllowedOrigins,
+		middleware.RequestID(
+			middleware.Tracing(mux, handlers.DeploymentName,
+				middleware.Logging(handlers.DeploymentName,
+					middleware.Metrics(m, mux,
+						middleware.Auth(authn, handlers.PublicPaths, mux))))))
 
 	// Start server
-	addr := fmt.Sprintf(":%s", cfg.Server.Port)
-	fmt.Printf("Server starting on port %s...\n", cfg.Server.Port)
-	log.Fatal(http.ListenAndServe(addr, handler))
+	srv := &http.Server{Addr: fmt.Sprintf(":%s", cfg.Server.Port), Handler: handler}
+	srv.RegisterOnShutdown(h.CloseConnections)
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "port", cfg.Server.Port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	// Wait for a signal, or for the server to fail
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed", "port", cfg.Server.Port, "error", err)
+		conn.Close()
+		os.Exit(1)
+	case sig := <-signals:
+		// A second signal kills the backend without waiting
+		signal.Stop(signals)
+		slog.Info("Shutting down", "signal", sig.String(), "timeout", cfg.Shutdown.Timeout.String())
+	}
+	// Each step gets Shutdown.Timeout of its own, so that a slow drain
+	// does not cut short stopping the functions
+	step := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	}
+
+	// Stop accepting connections and drain requests in progress
+	ctx, cancel := step()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Error draining requests", "error", err)
+		srv.Close()
+	}
+	cancel()
+
+	// Stop background work, then the functions it may have started
+	stopReaper()
+	ctx, cancel = step()
+	select {
+	case <-scheduler.Stop().Done():
+	case <-ctx.Done():
+		slog.Error("Error waiting for scheduled invocations", "error", ctx.Err())
+	}
+	if err := invocationQueue.Stop(ctx); err != nil {
+		slog.Error("Error stopping invocation queue", "error", err)
+	}
+	if err := jobManager.Shutdown(ctx); err != nil {
+		slog.Error("Error cancelling jobs", "error", err)
+	}
+	cancel()
+
+	// Functions are stopped, or killed, before the backend exits
+	ctx, cancel = step()
+	if err := h.Shutdown(ctx); err != nil {
+		slog.Error("Error shutting down functions", "error", err)
+	}
+	cancel()
+
+	// Flush buffered spans and the database
+	ctx, cancel = step()
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		slog.Error("Error flushing spans", "error", err)
+	}
+	if err := conn.Close(); err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// runCommand runs a maintenance command:
+//
+//	schema-version  print the applied and latest known schema version
+//	migrate         apply pending migrations and exit
+func runCommand(cfg *config.Config, name string) error {
+	switch name {
+	case "schema-version":
+		conn, err := db.Open(cfg.Function.DataDir)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		version, err := db.SchemaVersion(conn)
+		if err != nil {
+			return err
+		}
+		migrations, err := db.Migrations()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version: %d (latest: %d)\n", version, migrations[len(migrations)-1].Version)
+		return nil
+	case "migrate":
+		conn, err := db.InitDB(cfg.Function.DataDir)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		version, err := db.SchemaVersion(conn)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version: %d\n", version)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, expected schema-version or migrate", name)
+	}
 }
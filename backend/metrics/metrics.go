@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "serverless"
+
+// Operation outcomes
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// Metrics collects the platform's Prometheus metrics in its own registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	operations      *prometheus.HistogramVec
+	invocations     *prometheus.CounterVec
+	invocationTime  *prometheus.HistogramVec
+	invocationErrs  *prometheus.CounterVec
+}
+
+// New returns metrics registered with a new registry, together with the Go
+// runtime and process metrics of the backend
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled by the backend, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		operations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of function creates, builds and starts, by language and outcome.",
+			Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"operation", "language", "outcome"}),
+		invocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "function_invocations_total",
+			Help:      "Invocations of functions through the backend, by function and status code.",
+		}, []string{"function", "code"}),
+		invocationTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "function_invocation_duration_seconds",
+			Help:      "Latency of function invocations through the backend, including cold starts.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"function"}),
+		invocationErrs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "function_invocation_errors_total",
+			Help:      "Invocations that could not reach the function or got a 5xx response.",
+		}, []string{"function"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.requestDuration, m.operations,
+		m.invocations, m.invocationTime, m.invocationErrs,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Gauge registers a gauge whose value is read from fn at every scrape
+func (m *Metrics) Gauge(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// ObserveRequest records an HTTP request handled by the backend. route is
+// the pattern the request matched, to keep the number of series bounded.
+func (m *Metrics) ObserveRequest(route, method string, code int, d time.Duration) {
+	m.requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	m.requestDuration.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+// ObserveOperation records a create, build or start that began at started
+func (m *Metrics) ObserveOperation(operation, language, outcome string, started time.Time) {
+	m.operations.WithLabelValues(operation, language, outcome).Observe(time.Since(started).Seconds())
+}
+
+// ObserveInvocation records an invocation of a function that began at
+// started. code is 0 if the function could not be reached.
+func (m *Metrics) ObserveInvocation(function string, code int, started time.Time) {
+	m.invocations.WithLabelValues(function, strconv.Itoa(code)).Inc()
+	m.invocationTime.WithLabelValues(function).Observe(time.Since(started).Seconds())
+	if code == 0 || code >= 500 {
+		m.invocationErrs.WithLabelValues(function).Inc()
+	}
+}
+
+// Outcome returns the outcome of an operation that returned err, where
+// cancelled tells whether its context was cancelled
+func Outcome(err error, cancelled bool) string {
+	switch {
+	case err == nil:
+		return Succeeded
+	case cancelled:
+		return Cancelled
+	default:
+		return Failed
+	}
+}
@@ -1,14 +1,37 @@
 package middleware
 
-import "net/http"
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
 
-// CORS middleware
-func CORS(next http.Handler) http.Handler {
+	"main/auth"
+	"main/logging"
+	"main/metrics"
+	"main/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CORS middleware. Requests from origins not in allowedOrigins get no CORS
+// headers; "*" allows any origin.
+func CORS(allowedOrigins []string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if origin := r.Header.Get("Origin"); originAllowed(allowedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+		}
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -21,11 +44,216 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
-// Logging middleware
-func Logging(next http.Handler) http.Handler {
+func originAllowed(allowedOrigins []string, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, o := range allowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth middleware rejects requests without a valid session or API token,
+// except for the listed public paths. Public paths ending in / also match
+// every path below them.
+func Auth(authn *auth.Authenticator, publicPaths []string, next http.Handler) http.Handler {
+	public := make(map[string]bool, len(publicPaths))
+	var publicPrefixes []string
+	for _, p := range publicPaths {
+		public[p] = true
+		if strings.HasSuffix(p, "/") {
+			publicPrefixes = append(publicPrefixes, p)
+		}
+	}
+	isPublic := func(path string) bool {
+		if public[path] {
+			return true
+		}
+		for _, prefix := range publicPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Log request details
-		// TODO: Add proper logging
+		if isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := authn.Authenticate(r)
+		if err != nil {
+			if err != auth.ErrUnauthenticated {
+				slog.ErrorContext(r.Context(), "Error authenticating request", "error", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="serverless"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// The backend's credential is not passed on to function code
+		r = r.Clone(auth.WithUser(r.Context(), user))
+		auth.StripCredential(r)
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Metrics middleware records the route, status and duration of every
+// request. The route is the mux pattern the request matches.
+func Metrics(m *metrics.Metrics, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "other"
+		}
+		m.ObserveRequest(route, r.Method, rec.status, time.Since(started))
+	})
+}
+
+// statusRecorder remembers the status code written to a response. It
+// passes through flushes for streamed responses and hijacking for
+// WebSockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// maxRequestID is the longest X-Request-ID accepted from clients
+const maxRequestID = 128
+
+// RequestID middleware gives every request an ID, taken from its
+// X-Request-ID header or generated, and returns it in the response's
+// X-Request-ID header. Records logged with the request's context, including
+// by background work it starts, carry the ID.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII characters, which can be
+// logged and echoed back safely
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Logging middleware logs every request once it has been handled, with its
+// method, path, status and duration. The name of the deployment a request is
+// about, as returned by deploymentOf, is added to the request's context so
+// that handler logs carry it too.
+func Logging(deploymentOf func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		ctx := r.Context()
+		if name := deploymentOf(r); name != "" {
+			ctx = logging.WithDeployment(ctx, name)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		}
+		slog.LogAttrs(ctx, level, "Request handled",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(started).Microseconds())/1000),
+		)
+	})
+}
+
+// Tracing middleware starts a server span for every request, continuing the
+// trace of an incoming traceparent header. Spans are named after the route
+// pattern and carry the request ID and the deployment returned by
+// deploymentOf.
+func Tracing(mux *http.ServeMux, deploymentOf func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "other"
+		}
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", logging.RequestID(ctx)),
+			))
+		defer span.End()
+		if name := deploymentOf(r); name != "" {
+			span.SetAttributes(attribute.String("deployment", name))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kind names a lifecycle operation
+type Kind string
+
+const (
+	Create   Kind = "create"
+	Upload   Kind = "upload"
+	Build    Kind = "build"
+	Start    Kind = "start"
+	Stop     Kind = "stop"
+	Delete   Kind = "delete"
+	Rollback Kind = "rollback"
+	Restart  Kind = "restart"
+)
+
+// States of an operation
+const (
+	StateRunning = "running"
+	StateQueued  = "queued"
+)
+
+// Operation is a lifecycle operation that holds or waits for a deployment
+type Operation struct {
+	ID         string `json:"id"`
+	Deployment string `json:"deployment"`
+	Kind       Kind   `json:"kind"`
+	State      string `json:"state"`
+	QueuedAt   string `json:"queuedAt"`
+	StartedAt  string `json:"startedAt,omitempty"`
+}
+
+// ErrBusy is matched by the errors returned when a deployment is held by
+// another operation
+var ErrBusy = errors.New("deployment is busy")
+
+// BusyError describes the operation a deployment is busy with
+type BusyError struct {
+	Current Operation
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s is busy: %s operation %s in progress since %s",
+		e.Current.Deployment, e.Current.Kind, e.Current.ID, e.Current.StartedAt)
+}
+
+func (e *BusyError) Is(target error) bool {
+	return target == ErrBusy
+}
+
+// Coordinator serializes lifecycle operations per deployment. At most one
+// operation runs for a deployment at a time; others either fail with a
+// BusyError or wait their turn in FIFO order.
+type Coordinator struct {
+	mu    sync.Mutex
+	lanes map[string]*lane
+}
+
+// lane is the running and waiting operations of one deployment
+type lane struct {
+	current *Operation
+	pending []*waiter
+}
+
+type waiter struct {
+	op    *Operation
+	ready chan struct{}
+}
+
+func NewCoordinator() *Coordinator {
+	return &Coordinator{lanes: make(map[string]*lane)}
+}
+
+// Lease is held by a running operation until it is released
+type Lease struct {
+	c    *Coordinator
+	op   *Operation
+	once sync.Once
+}
+
+// Operation returns the operation holding the lease
+func (l *Lease) Operation() Operation {
+	l.c.mu.Lock()
+	defer l.c.mu.Unlock()
+	return *l.op
+}
+
+// Release ends the operation and hands the deployment to the next queued
+// operation, if any. It may be called more than once.
+func (l *Lease) Release() {
+	l.once.Do(func() { l.c.release(l.op) })
+}
+
+// Begin claims a deployment for an operation. If another operation holds
+// it, Begin returns a BusyError, or with queue set waits until every
+// operation queued before it has finished or ctx is done.
+func (c *Coordinator) Begin(ctx context.Context, name string, kind Kind, queue bool) (*Lease, error) {
+	now := time.Now().Format(time.RFC3339)
+	op := &Operation{ID: uuid.New().String(), Deployment: name, Kind: kind, QueuedAt: now}
+
+	c.mu.Lock()
+	ln, ok := c.lanes[name]
+	if !ok {
+		op.State = StateRunning
+		op.StartedAt = now
+		c.lanes[name] = &lane{current: op}
+		c.mu.Unlock()
+		return &Lease{c: c, op: op}, nil
+	}
+	if !queue {
+		current := *ln.current
+		c.mu.Unlock()
+		return nil, &BusyError{Current: current}
+	}
+	op.State = StateQueued
+	w := &waiter{op: op, ready: make(chan struct{})}
+	ln.pending = append(ln.pending, w)
+	c.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return &Lease{c: c, op: op}, nil
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	select {
+	case <-w.ready:
+		// The deployment was handed over as ctx was cancelled
+		c.mu.Unlock()
+		c.release(op)
+		return nil, ctx.Err()
+	default:
+	}
+	for i, p := range ln.pending {
+		if p == w {
+			ln.pending = append(ln.pending[:i], ln.pending[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+func (c *Coordinator) release(op *Operation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ln, ok := c.lanes[op.Deployment]
+	if !ok || ln.current != op {
+		return
+	}
+	if len(ln.pending) == 0 {
+		delete(c.lanes, op.Deployment)
+		return
+	}
+	next := ln.pending[0]
+	ln.pending = ln.pending[1:]
+	next.op.State = StateRunning
+	next.op.StartedAt = time.Now().Format(time.RFC3339)
+	ln.current = next.op
+	close(next.ready)
+}
+
+// Operations returns the running operation of a deployment, or nil, and the
+// operations queued behind it in order
+func (c *Coordinator) Operations(name string) (*Operation, []Operation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := []Operation{}
+	ln, ok := c.lanes[name]
+	if !ok {
+		return nil, pending
+	}
+	current := *ln.current
+	for _, w := range ln.pending {
+		pending = append(pending, *w.op)
+	}
+	return &current, pending
+}
@@ -0,0 +1,157 @@
+package ports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrExhausted is returned when every port in the range is taken
+var ErrExhausted = errors.New("no free port in range")
+
+// Assignment is a port assigned to a deployment
+type Assignment struct {
+	Deployment string `json:"deployment"`
+	Port       int    `json:"port"`
+	AssignedAt string `json:"assignedAt"`
+}
+
+// Allocator assigns each deployment a port from a fixed range and keeps the
+// assignments in SQLite, so that a function listens on the same port every
+// time it starts
+type Allocator struct {
+	db       *sql.DB
+	min, max int
+
+	mu sync.Mutex
+}
+
+// NewAllocator returns an allocator handing out ports from min to max,
+// inclusive
+func NewAllocator(db *sql.DB, min, max int) (*Allocator, error) {
+	if min < 1 || max > 65535 || min > max {
+		return nil, fmt.Errorf("invalid port range %d-%d", min, max)
+	}
+	return &Allocator{db: db, min: min, max: max}, nil
+}
+
+// Assign returns the port of a deployment, assigning one if it has none yet.
+// A port that is outside the range or that another process is listening on
+// is replaced. Ports are probed starting from a position derived from the
+// deployment name.
+func (a *Allocator) Assign(ctx context.Context, name string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	taken := make(map[int]bool)
+	current := 0
+	rows, err := a.db.QueryContext(ctx, "SELECT deployment_name, port FROM port_assignments")
+	if err != nil {
+		return "", fmt.Errorf("error querying port assignments: %v", err)
+	}
+	for rows.Next() {
+		var deployment string
+		var port int
+		if err := rows.Scan(&deployment, &port); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("error scanning port assignment: %v", err)
+		}
+		if deployment == name {
+			current = port
+		} else {
+			taken[port] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error iterating port assignments: %v", err)
+	}
+
+	if current != 0 && a.inRange(current) {
+		if free(current) {
+			return strconv.Itoa(current), nil
+		}
+		slog.Warn("Port in use by another process, assigning a new one", "deployment", name, "port", current)
+	}
+
+	size := a.max - a.min + 1
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	start := int(h.Sum32() % uint32(size))
+	for i := 0; i < size; i++ {
+		port := a.min + (start+i)%size
+		if port == current || taken[port] || !free(port) {
+			continue
+		}
+		_, err := a.db.ExecContext(ctx, `
+			INSERT INTO port_assignments (deployment_name, port, assigned_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT (deployment_name) DO UPDATE SET port = excluded.port, assigned_at = excluded.assigned_at
+		`, name, port, time.Now().Format(time.RFC3339))
+		if err != nil {
+			return "", fmt.Errorf("error saving port assignment: %v", err)
+		}
+		if current != 0 {
+			slog.Info("Reassigned port", "deployment", name, "from", current, "to", port)
+		}
+		return strconv.Itoa(port), nil
+	}
+	return "", ErrExhausted
+}
+
+// Release frees the port of a deployment
+func (a *Allocator) Release(ctx context.Context, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.db.ExecContext(ctx, "DELETE FROM port_assignments WHERE deployment_name = ?", name); err != nil {
+		return fmt.Errorf("error releasing port: %v", err)
+	}
+	return nil
+}
+
+// List returns every assignment, ordered by port
+func (a *Allocator) List(ctx context.Context) ([]Assignment, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT deployment_name, port, assigned_at
+		FROM port_assignments
+		ORDER BY port
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying port assignments: %v", err)
+	}
+	defer rows.Close()
+
+	assignments := []Assignment{}
+	for rows.Next() {
+		var p Assignment
+		if err := rows.Scan(&p.Deployment, &p.Port, &p.AssignedAt); err != nil {
+			return nil, fmt.Errorf("error scanning port assignment: %v", err)
+		}
+		assignments = append(assignments, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating port assignments: %v", err)
+	}
+	return assignments, nil
+}
+
+func (a *Allocator) inRange(port int) bool {
+	return port >= a.min && port <= a.max
+}
+
+// free reports whether nothing is listening on the port
+func free(port int) bool {
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
@@ -0,0 +1,558 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"main/health"
+
+	"github.com/google/uuid"
+)
+
+// Invocation statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	// StatusDead invocations have used up their attempts and are kept as
+	// dead letters
+	StatusDead = "dead"
+)
+
+// maxResponse is how much of each response body is kept with an invocation
+const maxResponse = 1024
+
+// maxSucceeded is the number of delivered invocations kept per deployment
+const maxSucceeded = 100
+
+// pollInterval is how often idle workers look for invocations that have
+// become due
+const pollInterval = time.Second
+
+// Invocation is a request to a deployment's function that is delivered in
+// the background
+type Invocation struct {
+	ID         string      `json:"id"`
+	Deployment string      `json:"deployment"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Headers    http.Header `json:"headers"`
+	// Body is only filled in when a single invocation is retrieved
+	Body     []byte `json:"-"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// NextAttemptAt is when a pending invocation is delivered next
+	NextAttemptAt  string `json:"nextAttemptAt,omitempty"`
+	LastStatusCode int    `json:"lastStatusCode,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	// Response is the start of the response body of a delivered invocation
+	Response  string `json:"response,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	// FailedAt is when a dead letter used up its last attempt
+	FailedAt string `json:"failedAt,omitempty"`
+	// Subscription is the topic subscription that published the
+	// invocation, if any
+	Subscription string `json:"subscription,omitempty"`
+}
+
+// Attempt is the outcome of one attempt to deliver an invocation
+type Attempt struct {
+	Invocation Invocation
+	Succeeded  bool
+	// Dead is set when a failed attempt was the last one
+	Dead    bool
+	Latency time.Duration
+	Err     error
+}
+
+// Deliverer calls the function of an invocation. The queue closes the
+// response body. Responses with a status of 400 or above count as failed
+// attempts.
+type Deliverer func(ctx context.Context, inv Invocation) (*http.Response, error)
+
+// Queue stores invocations in SQLite and delivers them with a pool of
+// workers, retrying failed attempts with exponential backoff and moving
+// invocations that fail every attempt to the dead letters
+type Queue struct {
+	db          *sql.DB
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	timeout     time.Duration
+
+	deliver Deliverer
+	observe func(Attempt)
+	// wake signals an idle worker that an invocation is due
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// mu serializes claiming invocations
+	mu sync.Mutex
+}
+
+// NewQueue returns a queue that makes up to maxAttempts attempts to
+// deliver each invocation, waiting backoff after the first failed attempt
+// and twice as long after each further one, up to maxBackoff. Attempts are
+// cancelled after timeout. Invocations left running by a previous backend
+// are delivered again.
+func NewQueue(db *sql.DB, maxAttempts int, backoff, maxBackoff, timeout time.Duration) (*Queue, error) {
+	_, err := db.Exec("UPDATE invocations SET status = ? WHERE status = ?", StatusPending, StatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("error requeueing interrupted invocations: %v", err)
+	}
+	return &Queue{
+		db:          db,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		timeout:     timeout,
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+// Start delivers invocations with the given number of workers, calling
+// deliver for each attempt
+func (q *Queue) Start(deliver Deliverer, workers int) {
+	q.deliver = deliver
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+}
+
+// Observe calls fn after every attempt that is not interrupted by Stop. It
+// must be called before Start.
+func (q *Queue) Observe(fn func(Attempt)) {
+	q.observe = fn
+}
+
+// Stop stops the workers and waits until they have finished or ctx is
+// done. Attempts in progress are cancelled and the invocations are
+// delivered again after the next Start.
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.cancel != nil {
+		q.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for invocation workers: %v", ctx.Err())
+	}
+}
+
+// Enqueue stores a new invocation for delivery
+func (q *Queue) Enqueue(ctx context.Context, inv Invocation) (*Invocation, error) {
+	list, err := q.EnqueueAll(ctx, []Invocation{inv})
+	if err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// EnqueueAll stores several new invocations for delivery, either all or
+// none of them
+func (q *Queue) EnqueueAll(ctx context.Context, invs []Invocation) ([]Invocation, error) {
+	now := time.Now()
+	queued := make([]Invocation, len(invs))
+	err := q.withinTx(ctx, func(tx *sql.Tx) error {
+		for i, inv := range invs {
+			headers, err := json.Marshal(inv.Headers)
+			if err != nil {
+				return err
+			}
+			inv.ID = uuid.New().String()
+			inv.Status = StatusPending
+			inv.Attempts = 0
+			inv.NextAttemptAt = now.Format(time.RFC3339)
+			inv.CreatedAt = now.Format(time.RFC3339)
+			inv.UpdatedAt = inv.CreatedAt
+
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO invocations (id, deployment_name, method, path, headers, body, status, next_attempt_at,
+					created_at, updated_at, subscription_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, inv.ID, inv.Deployment, inv.Method, inv.Path, string(headers), inv.Body, inv.Status,
+				now.UnixMilli(), inv.CreatedAt, inv.UpdatedAt, inv.Subscription)
+			if err != nil {
+				return err
+			}
+			queued[i] = inv
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error enqueueing invocation: %v", err)
+	}
+	q.signal()
+	return queued, nil
+}
+
+// Get retrieves an invocation with its body, whether it is queued,
+// delivered or a dead letter, or nil if it does not exist
+func (q *Queue) Get(ctx context.Context, id string) (*Invocation, error) {
+	list, err := q.query(ctx, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return q.DeadLetter(ctx, id)
+	}
+	return &list[0], nil
+}
+
+// List returns the queued and most recently delivered invocations of a
+// deployment, newest first, without their bodies
+func (q *Queue) List(ctx context.Context, deployment string, limit int) ([]Invocation, error) {
+	list, err := q.query(ctx, "WHERE deployment_name = ? ORDER BY created_at DESC, rowid DESC LIMIT ?", deployment, limit)
+	for i := range list {
+		list[i].Body = nil
+	}
+	return list, err
+}
+
+// DeadLetter retrieves a dead letter with its body, or nil if it does not
+// exist
+func (q *Queue) DeadLetter(ctx context.Context, id string) (*Invocation, error) {
+	list, err := q.queryDead(ctx, "WHERE id = ?", id)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// DeadLetters returns the most recent dead letters, newest first, without
+// their bodies. An empty deployment returns those of every deployment.
+func (q *Queue) DeadLetters(ctx context.Context, deployment string, limit int) ([]Invocation, error) {
+	var list []Invocation
+	var err error
+	if deployment == "" {
+		list, err = q.queryDead(ctx, "ORDER BY failed_at DESC, rowid DESC LIMIT ?", limit)
+	} else {
+		list, err = q.queryDead(ctx, "WHERE deployment_name = ? ORDER BY failed_at DESC, rowid DESC LIMIT ?", deployment, limit)
+	}
+	for i := range list {
+		list[i].Body = nil
+	}
+	return list, err
+}
+
+// Requeue moves a dead letter back to the queue to be delivered right away
+// with a fresh set of attempts. It returns nil if the dead letter does not
+// exist.
+func (q *Queue) Requeue(ctx context.Context, id string) (*Invocation, error) {
+	inv, err := q.DeadLetter(ctx, id)
+	if err != nil || inv == nil {
+		return nil, err
+	}
+	headers, err := json.Marshal(inv.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding invocation headers: %v", err)
+	}
+	now := time.Now()
+	inv.Status = StatusPending
+	inv.Attempts = 0
+	inv.NextAttemptAt = now.Format(time.RFC3339)
+	inv.UpdatedAt = now.Format(time.RFC3339)
+	inv.FailedAt = ""
+
+	err = q.withinTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM dead_letters WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			inv = nil
+			return nil
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO invocations (id, deployment_name, method, path, headers, body, status, next_attempt_at,
+				last_status_code, last_error, created_at, updated_at, subscription_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, inv.ID, inv.Deployment, inv.Method, inv.Path, string(headers), inv.Body, inv.Status, now.UnixMilli(),
+			inv.LastStatusCode, inv.LastError, inv.CreatedAt, inv.UpdatedAt, inv.Subscription)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error requeueing invocation: %v", err)
+	}
+	q.signal()
+	return inv, nil
+}
+
+// DeleteDeadLetter removes a dead letter. It reports whether it existed.
+func (q *Queue) DeleteDeadLetter(ctx context.Context, id string) (bool, error) {
+	res, err := q.db.ExecContext(ctx, "DELETE FROM dead_letters WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting dead letter: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteAll removes the invocations and dead letters of a deployment
+func (q *Queue) DeleteAll(ctx context.Context, deployment string) error {
+	if _, err := q.db.ExecContext(ctx, "DELETE FROM invocations WHERE deployment_name = ?", deployment); err != nil {
+		return fmt.Errorf("error deleting invocations: %v", err)
+	}
+	if _, err := q.db.ExecContext(ctx, "DELETE FROM dead_letters WHERE deployment_name = ?", deployment); err != nil {
+		return fmt.Errorf("error deleting dead letters: %v", err)
+	}
+	return nil
+}
+
+// signal wakes an idle worker, if there is one
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work delivers due invocations until ctx is cancelled
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+	for ctx.Err() == nil {
+		inv, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Error claiming invocation", "error", err)
+		}
+		if inv == nil {
+			select {
+			case <-ctx.Done():
+			case <-q.wake:
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		q.attempt(ctx, inv)
+	}
+}
+
+// claim marks the invocation that has been due the longest as running and
+// counts the attempt. It returns nil if no invocation is due.
+func (q *Queue) claim(ctx context.Context) (*Invocation, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var id string
+	err := q.db.QueryRowContext(ctx, `
+		SELECT id FROM invocations
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, rowid
+		LIMIT 1
+	`, StatusPending, time.Now().UnixMilli()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = q.db.ExecContext(ctx, "UPDATE invocations SET status = ?, attempts = attempts + 1, updated_at = ? WHERE id = ?",
+		StatusRunning, time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		return nil, err
+	}
+	list, err := q.query(ctx, "WHERE id = ?", id)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// attempt delivers an invocation once and records the outcome
+func (q *Queue) attempt(ctx context.Context, inv *Invocation) {
+	attemptCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	started := time.Now()
+	resp, err := q.deliver(attemptCtx, *inv)
+	statusCode, response := 0, ""
+	if err == nil {
+		statusCode = resp.StatusCode
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+		resp.Body.Close()
+		response = string(body)
+		if statusCode >= 400 {
+			err = fmt.Errorf("function returned %s", resp.Status)
+		}
+	}
+	cancel()
+
+	now := time.Now()
+	stopping := ctx.Err() != nil
+	// The outcome is recorded even if the queue is stopping
+	ctx = context.WithoutCancel(ctx)
+	if stopping {
+		// The queue is stopping; the attempt doesn't count
+		_, err := q.db.ExecContext(ctx, "UPDATE invocations SET status = ?, attempts = attempts - 1 WHERE id = ?",
+			StatusPending, inv.ID)
+		if err != nil {
+			slog.Error("Error updating invocation", "deployment", inv.Deployment, "invocation", inv.ID, "error", err)
+		}
+		return
+	}
+
+	var dbErr error
+	switch {
+
+	case err == nil:
+		_, dbErr = q.db.ExecContext(ctx, `
+			UPDATE invocations SET status = ?, last_status_code = ?, last_error = '', response = ?, updated_at = ?
+			WHERE id = ?
+		`, StatusSucceeded, statusCode, response, now.Format(time.RFC3339), inv.ID)
+		if dbErr == nil {
+			dbErr = q.prune(ctx, inv.Deployment)
+		}
+
+	case inv.Attempts >= q.maxAttempts:
+		slog.Warn("Invocation failed, dead-lettering", "deployment", inv.Deployment, "invocation", inv.ID, "attempts", inv.Attempts, "error", err)
+		dbErr = q.bury(ctx, inv, statusCode, err.Error(), now)
+
+	default:
+		delay := health.Backoff(inv.Attempts, q.backoff, q.maxBackoff)
+		slog.Warn("Invocation attempt failed, retrying", "deployment", inv.Deployment, "invocation", inv.ID, "attempt", inv.Attempts, "delay", delay.String(), "error", err)
+		_, dbErr = q.db.ExecContext(ctx, `
+			UPDATE invocations SET status = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, updated_at = ?
+			WHERE id = ?
+		`, StatusPending, now.Add(delay).UnixMilli(), statusCode, err.Error(), now.Format(time.RFC3339), inv.ID)
+	}
+	if dbErr != nil {
+		slog.Error("Error updating invocation", "deployment", inv.Deployment, "invocation", inv.ID, "error", dbErr)
+	}
+	if q.observe != nil {
+		q.observe(Attempt{
+			Invocation: *inv,
+			Succeeded:  err == nil,
+			Dead:       err != nil && inv.Attempts >= q.maxAttempts,
+			Latency:    now.Sub(started),
+			Err:        err,
+		})
+	}
+}
+
+// bury moves an invocation that failed its last attempt to the dead letters
+func (q *Queue) bury(ctx context.Context, inv *Invocation, statusCode int, lastError string, now time.Time) error {
+	headers, err := json.Marshal(inv.Headers)
+	if err != nil {
+		return err
+	}
+	return q.withinTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO dead_letters (id, deployment_name, method, path, headers, body, attempts,
+				last_status_code, last_error, created_at, failed_at, subscription_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, inv.ID, inv.Deployment, inv.Method, inv.Path, string(headers), inv.Body, inv.Attempts,
+			statusCode, lastError, inv.CreatedAt, now.Format(time.RFC3339), inv.Subscription)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM invocations WHERE id = ?", inv.ID)
+		return err
+	})
+}
+
+// prune drops the oldest delivered invocations of a deployment beyond the
+// most recent ones
+func (q *Queue) prune(ctx context.Context, deployment string) error {
+	_, err := q.db.ExecContext(ctx, `
+		DELETE FROM invocations
+		WHERE deployment_name = ? AND status = ? AND rowid NOT IN (
+			SELECT rowid FROM invocations WHERE deployment_name = ? AND status = ?
+			ORDER BY updated_at DESC, rowid DESC LIMIT ?
+		)
+	`, deployment, StatusSucceeded, deployment, StatusSucceeded, maxSucceeded)
+	return err
+}
+
+func (q *Queue) withinTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (q *Queue) query(ctx context.Context, where string, args ...interface{}) ([]Invocation, error) {
+	rows, err := q.db.QueryContext(ctx, `
+		SELECT id, deployment_name, method, path, headers, body, status, attempts, next_attempt_at,
+			last_status_code, last_error, response, created_at, updated_at, subscription_id
+		FROM invocations
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying invocations: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Invocation{}
+	for rows.Next() {
+		var inv Invocation
+		var headers string
+		var nextAttemptAt int64
+		err := rows.Scan(&inv.ID, &inv.Deployment, &inv.Method, &inv.Path, &headers, &inv.Body, &inv.Status,
+			&inv.Attempts, &nextAttemptAt, &inv.LastStatusCode, &inv.LastError, &inv.Response, &inv.CreatedAt, &inv.UpdatedAt, &inv.Subscription)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning invocation: %v", err)
+		}
+		if err := json.Unmarshal([]byte(headers), &inv.Headers); err != nil {
+			return nil, fmt.Errorf("error decoding invocation headers: %v", err)
+		}
+		if inv.Status == StatusPending {
+			inv.NextAttemptAt = time.UnixMilli(nextAttemptAt).Format(time.RFC3339)
+		}
+		list = append(list, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invocations: %v", err)
+	}
+	return list, nil
+}
+
+func (q *Queue) queryDead(ctx context.Context, where string, args ...interface{}) ([]Invocation, error) {
+	rows, err := q.db.QueryContext(ctx, `
+		SELECT id, deployment_name, method, path, headers, body, attempts, last_status_code, last_error,
+			created_at, failed_at, subscription_id
+		FROM dead_letters
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying dead letters: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Invocation{}
+	for rows.Next() {
+		inv := Invocation{Status: StatusDead}
+		var headers string
+		err := rows.Scan(&inv.ID, &inv.Deployment, &inv.Method, &inv.Path, &headers, &inv.Body, &inv.Attempts,
+			&inv.LastStatusCode, &inv.LastError, &inv.CreatedAt, &inv.FailedAt, &inv.Subscription)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning dead letter: %v", err)
+		}
+		if err := json.Unmarshal([]byte(headers), &inv.Headers); err != nil {
+			return nil, fmt.Errorf("error decoding invocation headers: %v", err)
+		}
+		inv.UpdatedAt = inv.FailedAt
+		list = append(list, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating dead letters: %v", err)
+	}
+	return list, nil
+}
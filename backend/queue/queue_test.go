@@ -0,0 +1,194 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"main/db"
+)
+
+func newTestQueue(t *testing.T, conn *sql.DB, maxAttempts int) *Queue {
+	t.Helper()
+	q, err := NewQueue(conn, maxAttempts, 10*time.Millisecond, 20*time.Millisecond, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	conn, err := db.InitDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// respond returns a response with code, as a function would
+func respond(code int) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Body:       io.NopCloser(strings.NewReader(http.StatusText(code))),
+	}
+}
+
+// start starts q with one worker, which is stopped when the test ends
+func start(t *testing.T, q *Queue, deliver Deliverer) {
+	t.Helper()
+	q.Start(deliver, 1)
+	t.Cleanup(func() { q.Stop(context.Background()) })
+}
+
+// waitStatus waits for an invocation to reach status and returns it
+func waitStatus(t *testing.T, q *Queue, id, status string) *Invocation {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		inv, err := q.Get(context.Background(), id)
+		if err != nil || inv == nil {
+			t.Fatalf("got %v, error %v", inv, err)
+		}
+		if inv.Status == status {
+			return inv
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("invocation still %s after 10s, want %s: %+v", inv.Status, status, inv)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRetryThenDeadLetter(t *testing.T) {
+	q := newTestQueue(t, newTestDB(t), 3)
+	ctx := context.Background()
+	var mu sync.Mutex
+	var delivered []time.Time
+	start(t, q, func(ctx context.Context, inv Invocation) (*http.Response, error) {
+		mu.Lock()
+		delivered = append(delivered, time.Now())
+		mu.Unlock()
+		return respond(http.StatusInternalServerError), nil
+	})
+
+	inv, err := q.Enqueue(ctx, Invocation{Deployment: "hello", Method: http.MethodPost, Path: "/", Body: []byte("event")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := waitStatus(t, q, inv.ID, StatusDead)
+	if dead.Attempts != 3 || string(dead.Body) != "event" || dead.FailedAt == "" ||
+		dead.LastStatusCode != http.StatusInternalServerError {
+		t.Fatalf("got dead letter %+v", dead)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(delivered))
+	}
+	// Retries wait for the backoff, doubling up to the maximum
+	for i, min := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		if gap := delivered[i+1].Sub(delivered[i]); gap < min {
+			t.Errorf("retry %d after %v, want at least %v", i+1, gap, min)
+		}
+	}
+
+	if list, err := q.List(ctx, "hello", 10); err != nil || len(list) != 0 {
+		t.Fatalf("got queued invocations %+v, error %v", list, err)
+	}
+	if list, err := q.DeadLetters(ctx, "", 10); err != nil || len(list) != 1 || list[0].ID != inv.ID {
+		t.Fatalf("got dead letters %+v, error %v", list, err)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	q := newTestQueue(t, newTestDB(t), 1)
+	ctx := context.Background()
+	var code atomic.Int32
+	code.Store(http.StatusBadGateway)
+	start(t, q, func(ctx context.Context, inv Invocation) (*http.Response, error) {
+		return respond(int(code.Load())), nil
+	})
+
+	inv, err := q.Enqueue(ctx, Invocation{Deployment: "hello", Method: http.MethodPost, Path: "/", Body: []byte("event")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, q, inv.ID, StatusDead)
+
+	code.Store(http.StatusOK)
+	requeued, err := q.Requeue(ctx, inv.ID)
+	if err != nil || requeued == nil {
+		t.Fatalf("got %v, error %v", requeued, err)
+	}
+	if requeued.Status != StatusPending || requeued.Attempts != 0 {
+		t.Fatalf("got requeued invocation %+v", requeued)
+	}
+
+	// Requeued invocations get a fresh set of attempts
+	got := waitStatus(t, q, inv.ID, StatusSucceeded)
+	if got.Attempts != 1 || got.Response != "OK" {
+		t.Fatalf("got %+v, want a successful first attempt", got)
+	}
+	if dead, err := q.DeadLetter(ctx, inv.ID); err != nil || dead != nil {
+		t.Fatalf("dead letter still there: %+v, error %v", dead, err)
+	}
+	if requeued, err := q.Requeue(ctx, inv.ID); err != nil || requeued != nil {
+		t.Fatalf("requeued a delivered invocation: %+v, error %v", requeued, err)
+	}
+}
+
+func TestStopDuringDelivery(t *testing.T) {
+	conn := newTestDB(t)
+	q := newTestQueue(t, conn, 3)
+	ctx := context.Background()
+	delivering := make(chan struct{})
+	release := make(chan struct{})
+	q.Start(func(ctx context.Context, inv Invocation) (*http.Response, error) {
+		close(delivering)
+		// A function that doesn't give up when the queue stops
+		<-release
+		return nil, ctx.Err()
+	}, 1)
+
+	inv, err := q.Enqueue(ctx, Invocation{Deployment: "hello", Method: http.MethodPost, Path: "/", Body: []byte("event")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-delivering
+
+	// Stop gives up waiting when its context is done
+	stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := q.Stop(stopCtx); err == nil {
+		t.Fatal("Stop returned while the delivery was in flight")
+	}
+	close(release)
+	if err := q.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interrupted attempt doesn't count
+	got, err := q.Get(ctx, inv.ID)
+	if err != nil || got.Status != StatusPending || got.Attempts != 0 {
+		t.Fatalf("got %+v, error %v", got, err)
+	}
+
+	// and the invocation is delivered by the next queue
+	q = newTestQueue(t, conn, 3)
+	start(t, q, func(ctx context.Context, inv Invocation) (*http.Response, error) {
+		return respond(http.StatusOK), nil
+	})
+	if got := waitStatus(t, q, inv.ID, StatusSucceeded); got.Attempts != 1 {
+		t.Fatalf("got %+v, want the invocation delivered on its first attempt", got)
+	}
+}
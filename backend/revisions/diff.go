@@ -0,0 +1,123 @@
+package revisions
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the LCS table. Larger inputs are shown as
+// a full replacement.
+const maxDiffCells = 4 << 20
+
+// Diff returns a unified diff of two texts, or "" if they are equal
+func Diff(fromName, toName, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	// Group changes into hunks with three lines of context, merging hunks
+	// whose context overlaps
+	const context = 3
+	var hunks [][2]int
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		start, stop := max(0, i-context), min(len(ops), i+context+1)
+		if n := len(hunks); n > 0 && start <= hunks[n-1][1] {
+			hunks[n-1][1] = stop
+		} else {
+			hunks = append(hunks, [2]int{start, stop})
+		}
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range hunks {
+		lines := ops[hunk[0]:hunk[1]]
+		aLen, bLen := 0, 0
+		for _, op := range lines {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", lines[0].a+1, aLen, lines[0].b+1, bLen)
+		for _, op := range lines {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.text)
+		}
+	}
+	return out.String()
+}
+
+// diffOp is one line of an edit script. a and b are the line indexes in the
+// old and new text at which the op applies.
+type diffOp struct {
+	kind byte
+	text string
+	a, b int
+}
+
+func diffLines(a, b []string) []diffOp {
+	if len(a)*len(b) > maxDiffCells {
+		var ops []diffOp
+		for i, l := range a {
+			ops = append(ops, diffOp{'-', l, i, 0})
+		}
+		for j, l := range b {
+			ops = append(ops, diffOp{'+', l, len(a), j})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:], b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i], i, j})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j], i, j})
+	}
+	return ops
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,186 @@
+package revisions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Build statuses of a revision
+const (
+	BuildPending = "pending"
+	BuildBuilt   = "built"
+	BuildFailed  = "failed"
+)
+
+// Revision is an immutable snapshot of a deployment's code and package file
+type Revision struct {
+	ID          string `json:"id"`
+	Deployment  string `json:"deployment"`
+	Number      int    `json:"number"`
+	CodeFile    string `json:"codeFile"`
+	PackageFile string `json:"packageFile"`
+	Code        string `json:"code,omitempty"`
+	Package     string `json:"package,omitempty"`
+	BuildStatus string `json:"buildStatus"`
+	Image       string `json:"image,omitempty"`
+	// RollbackOf is the number of the revision this one restored, if any
+	RollbackOf int    `json:"rollbackOf,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// Store keeps revision metadata in SQLite and a copy of each revision's
+// files under dir/<name>/<number>/
+type Store struct {
+	db  *sql.DB
+	dir string
+}
+
+// NewStore returns a store backed by the revisions table, creating dir if
+// needed
+func NewStore(db *sql.DB, dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating revisions directory: %v", err)
+	}
+	return &Store{db: db, dir: dir}, nil
+}
+
+// Create stores a new revision numbered after the latest one
+func (s *Store) Create(ctx context.Context, r Revision) (*Revision, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating revision: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(number), 0) + 1 FROM revisions WHERE deployment_name = ?
+	`, r.Deployment).Scan(&r.Number)
+	if err != nil {
+		return nil, fmt.Errorf("error numbering revision: %v", err)
+	}
+	r.ID = uuid.New().String()
+	r.BuildStatus = BuildPending
+	r.Image = ""
+	r.CreatedAt = time.Now().Format(time.RFC3339)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO revisions (id, deployment_name, number, code_file, package_file, code, package,
+			build_status, image, rollback_of, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.ID, r.Deployment, r.Number, r.CodeFile, r.PackageFile, r.Code, r.Package,
+		r.BuildStatus, r.Image, r.RollbackOf, r.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating revision: %v", err)
+	}
+
+	dir := filepath.Join(s.dir, r.Deployment, fmt.Sprint(r.Number))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating revision directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, r.CodeFile), []byte(r.Code), 0644); err != nil {
+		return nil, fmt.Errorf("error saving revision code: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, r.PackageFile), []byte(r.Package), 0644); err != nil {
+		return nil, fmt.Errorf("error saving revision package: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error creating revision: %v", err)
+	}
+	return &r, nil
+}
+
+// Get retrieves a revision including its files, or nil if it does not exist
+func (s *Store) Get(ctx context.Context, name string, number int) (*Revision, error) {
+	return s.scanOne(s.db.QueryRowContext(ctx, `
+		SELECT id, deployment_name, number, code_file, package_file, code, package,
+			build_status, image, rollback_of, created_at
+		FROM revisions
+		WHERE deployment_name = ? AND number = ?
+	`, name, number))
+}
+
+// Latest retrieves the newest revision of a deployment, or nil if it has none
+func (s *Store) Latest(ctx context.Context, name string) (*Revision, error) {
+	return s.scanOne(s.db.QueryRowContext(ctx, `
+		SELECT id, deployment_name, number, code_file, package_file, code, package,
+			build_status, image, rollback_of, created_at
+		FROM revisions
+		WHERE deployment_name = ?
+		ORDER BY number DESC
+		LIMIT 1
+	`, name))
+}
+
+func (s *Store) scanOne(row *sql.Row) (*Revision, error) {
+	var r Revision
+	err := row.Scan(&r.ID, &r.Deployment, &r.Number, &r.CodeFile, &r.PackageFile, &r.Code, &r.Package,
+		&r.BuildStatus, &r.Image, &r.RollbackOf, &r.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting revision: %v", err)
+	}
+	return &r, nil
+}
+
+// List returns the revisions of a deployment, newest first, without their
+// file contents
+func (s *Store) List(ctx context.Context, name string) ([]Revision, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, deployment_name, number, code_file, package_file,
+			build_status, image, rollback_of, created_at
+		FROM revisions
+		WHERE deployment_name = ?
+		ORDER BY number DESC
+	`, name)
+	if err != nil {
+		return nil, fmt.Errorf("error querying revisions: %v", err)
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var r Revision
+		err := rows.Scan(&r.ID, &r.Deployment, &r.Number, &r.CodeFile, &r.PackageFile,
+			&r.BuildStatus, &r.Image, &r.RollbackOf, &r.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning revision: %v", err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+// SetBuildResult records the outcome of building a revision
+func (s *Store) SetBuildResult(ctx context.Context, name string, number int, status, image string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE revisions SET build_status = ?, image = ?
+		WHERE deployment_name = ? AND number = ?
+	`, status, image, name, number)
+	if err != nil {
+		return fmt.Errorf("error updating revision: %v", err)
+	}
+	return nil
+}
+
+// Delete removes every revision of a deployment
+func (s *Store) Delete(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revisions WHERE deployment_name = ?", name); err != nil {
+		return fmt.Errorf("error deleting revisions: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("error deleting revision files: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"main/config"
+
+	"github.com/creack/pty"
+)
+
+// Knative runs functions through the Knative `func` CLI
+type Knative struct {
+	registry string
+}
+
+func NewKnative(cfg *config.Config) *Knative {
+	return &Knative{registry: cfg.Registry.Address}
+}
+
+func (k *Knative) Create(ctx context.Context, fn Function) ([]byte, error) {
+	cmd := commandContext(ctx, "func", "create", "-l", fn.Language, fn.Name)
+	cmd.Dir = filepath.Dir(fn.Dir)
+	span := traceCommand(ctx, fn, cmd)
+	output, err := cmd.CombinedOutput()
+	endCommand(span, cmd, err)
+	return output, err
+}
+
+func (k *Knative) Build(ctx context.Context, fn Function, out io.Writer) (*BuildResult, error) {
+	cmd := commandContext(ctx, "func", "build", fn.Name, "--registry", k.registry)
+	cmd.Dir = fn.Dir
+	cmd.Env = fn.environ()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	span := traceCommand(ctx, fn, cmd)
+	err := cmd.Run()
+	endCommand(span, cmd, err)
+	if err != nil {
+		return nil, err
+	}
+	return &BuildResult{Image: fmt.Sprintf("%s/%s:latest", k.registry, fn.Name)}, nil
+}
+
+func (k *Knative) Run(ctx context.Context, fn Function) (*Process, error) {
+	cmd := exec.Command("func", "run", fn.Name, "--registry", k.registry)
+	cmd.Dir = fn.Dir
+	cmd.Env = fn.environ()
+
+	// func run only prints its output when attached to a terminal. Its span
+	// covers launching it; the function keeps running afterwards.
+	span := traceCommand(ctx, fn, cmd)
+	ptmx, err := pty.Start(cmd)
+	endCommand(span, cmd, err)
+	if err != nil {
+		return nil, err
+	}
+	return newProcess(fn.Name, cmd, ptmx), nil
+}
+
+// AcceptsPort is false: func run picks the host port of the container
+func (k *Knative) AcceptsPort() bool {
+	return false
+}
+
+// Detachable is false: func run exits once the terminal the backend
+// attached it to is closed
+func (k *Knative) Detachable() bool {
+	return false
+}
+
+func (k *Knative) Stop(p *Process) error {
+	defer p.closeOutput()
+	if p.process == nil {
+		return nil
+	}
+
+	// func run leaves a container behind unless its children see SIGINT too
+	if err := exec.Command("pkill", "-INT", "-P", fmt.Sprintf("%d", p.Pid())).Run(); err != nil {
+		slog.Warn("Error sending SIGINT to process group", "pid", p.Pid(), "error", err)
+	}
+	return interruptAndWait(p, 10*time.Second)
+}
+
+func (k *Knative) Adopt(fn Function, pid int, port string) (*Process, error) {
+	return adoptProcess(fn, pid, port)
+}
+
+func (k *Knative) Status(p *Process) Status {
+	return processStatus(p)
+}
+
+// interruptAndWait sends SIGINT to the process and kills it if it has not
+// exited within the timeout
+func interruptAndWait(p *Process, timeout time.Duration) error {
+	if err := p.process.Signal(os.Interrupt); err != nil {
+		slog.Warn("Error sending SIGINT to process", "pid", p.Pid(), "error", err)
+	}
+
+	select {
+	case <-p.Done():
+		if err := p.Err(); err != nil && err.Error() != "signal: interrupt" {
+			slog.Error("Error waiting for process", "pid", p.Pid(), "error", err)
+		}
+		return nil
+	case <-time.After(timeout):
+		slog.Warn("Process did not exit after SIGINT, forcing kill", "pid", p.Pid(), "timeout", timeout)
+		if err := p.process.Kill(); err != nil {
+			return fmt.Errorf("error killing process: %v", err)
+		}
+		<-p.Done()
+		return nil
+	}
+}
@@ -0,0 +1,268 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"main/config"
+)
+
+// Native runs functions as plain local processes using the language
+// toolchains installed on the host, without containers or the func CLI
+type Native struct{}
+
+func NewNative(cfg *config.Config) *Native {
+	return &Native{}
+}
+
+// nativeTemplates holds the scaffold files for each language. Every
+// template serves HTTP on the port given in the PORT environment variable.
+var nativeTemplates = map[string]map[string]string{
+	"node": {
+		"index.js": `const http = require("http");
+
+const port = process.env.PORT || 8080;
+
+http.createServer((req, res) => {
+  res.writeHead(200, { "Content-Type": "application/json" });
+  res.end(JSON.stringify({ message: "Hello from node" }));
+}).listen(port, () => console.log("listening on port " + port));
+`,
+		"package.json": `{
+  "name": "function",
+  "version": "0.1.0",
+  "main": "index.js"
+}
+`,
+	},
+	"python": {
+		"func.py": `import json
+import os
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+
+class Handler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.end_headers()
+        self.wfile.write(json.dumps({"message": "Hello from python"}).encode())
+
+    do_POST = do_GET
+
+
+if __name__ == "__main__":
+    port = int(os.environ.get("PORT", "8080"))
+    print("listening on port %d" % port, flush=True)
+    HTTPServer(("", port), Handler).serve_forever()
+`,
+		"requirements.txt": "",
+	},
+	"go": {
+		"handle.go": `package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, ` + "`" + `{"message":"Hello from go"}` + "`" + `)
+	})
+	log.Printf("listening on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
+`,
+		"go.mod": "module function\n\ngo 1.21\n",
+	},
+}
+
+func (n *Native) Create(ctx context.Context, fn Function) ([]byte, error) {
+	files, ok := nativeTemplates[fn.Language]
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", fn.Language)
+	}
+	if err := os.MkdirAll(fn.Dir, 0755); err != nil {
+		return nil, err
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(fn.Dir, file), []byte(content), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(fmt.Sprintf("Created %s function in %s\n", fn.Language, fn.Dir)), nil
+}
+
+func (n *Native) Build(ctx context.Context, fn Function, out io.Writer) (*BuildResult, error) {
+	var cmd *exec.Cmd
+	switch fn.Language {
+	case "python":
+		cmd = commandContext(ctx, "sh", "-c",
+			"python3 -m venv .venv && .venv/bin/pip install -r requirements.txt")
+	case "go":
+		cmd = commandContext(ctx, "go", "build", "-o", "function", ".")
+	default:
+		cmd = commandContext(ctx, "npm", "install")
+	}
+	cmd.Dir = fn.Dir
+	cmd.Env = fn.environ()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	span := traceCommand(ctx, fn, cmd)
+	err := cmd.Run()
+	endCommand(span, cmd, err)
+	if err != nil {
+		return nil, err
+	}
+	return &BuildResult{}, nil
+}
+
+func (n *Native) Run(ctx context.Context, fn Function) (*Process, error) {
+	port := fn.Port
+	if port == "" {
+		var err error
+		if port, err = freePort(); err != nil {
+			return nil, fmt.Errorf("error finding free port: %v", err)
+		}
+	}
+
+	var cmd *exec.Cmd
+	switch fn.Language {
+	case "python":
+		cmd = exec.Command(filepath.Join(".venv", "bin", "python"), "func.py")
+	case "go":
+		cmd = exec.Command("./function")
+	default:
+		cmd = exec.Command("node", "index.js")
+	}
+	cmd.Dir = fn.Dir
+	cmd.Env = fn.environ("PORT=" + port)
+
+	// Output goes to a file rather than a pipe so that the function can
+	// keep writing to it once the backend that started it has exited
+	path := filepath.Join(fn.Dir, outputFile)
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %v", err)
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("error opening output file: %v", err)
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	// A process group of its own keeps Ctrl-C in the backend's terminal
+	// from reaching the function, which the backend stops itself
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// The span covers launching the function, which keeps running
+	// afterwards
+	span := traceCommand(ctx, fn, cmd)
+	err = cmd.Start()
+	endCommand(span, cmd, err)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	// The child holds its own copy of the file
+	w.Close()
+
+	p := newProcess(fn.Name, cmd, nil)
+	p.Output = &fileTail{f: r, done: p.done, closed: make(chan struct{})}
+	p.Port = port
+	return p, nil
+}
+
+// AcceptsPort is true: functions are given their port in $PORT
+func (n *Native) AcceptsPort() bool {
+	return true
+}
+
+// Detachable is true: functions write their output to a file and keep
+// running without the backend
+func (n *Native) Detachable() bool {
+	return true
+}
+
+func (n *Native) Stop(p *Process) error {
+	defer p.closeOutput()
+	if p.process == nil {
+		return nil
+	}
+	return interruptAndWait(p, 10*time.Second)
+}
+
+func (n *Native) Adopt(fn Function, pid int, port string) (*Process, error) {
+	return adoptProcess(fn, pid, port)
+}
+
+func (n *Native) Status(p *Process) Status {
+	return processStatus(p)
+}
+
+// outputFile receives the output of a running function in its directory
+const outputFile = ".output"
+
+// fileTail reads a file that a process is still writing to, like tail -f,
+// until the process has exited and the rest of the file has been read
+type fileTail struct {
+	f      *os.File
+	done   <-chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (t *fileTail) Read(b []byte) (int, error) {
+	for {
+		n, err := t.f.Read(b)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		select {
+		case <-t.done:
+			// Pick up whatever was written just before the exit
+			if n, _ := t.f.Read(b); n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		case <-t.closed:
+			return 0, os.ErrClosed
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (t *fileTail) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+	})
+	return t.f.Close()
+}
+
+// freePort asks the kernel for an unused TCP port
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port), nil
+}
@@ -0,0 +1,231 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"main/config"
+	"main/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Status describes the state of a function process as seen by its runtime
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	StatusExited  Status = "exited"
+)
+
+// Function describes the function a runtime operates on
+type Function struct {
+	Name     string
+	Language string
+	// Dir is the function's source directory (DataDir/<name>)
+	Dir string
+	// Env holds KEY=value pairs added to the build and run environment
+	Env []string
+	// Port is the port Run should make the function listen on, for
+	// runtimes that accept one
+	Port string
+}
+
+// environ returns the backend's environment extended with the function's
+func (fn Function) environ(extra ...string) []string {
+	env := append(os.Environ(), fn.Env...)
+	return append(env, extra...)
+}
+
+// BuildResult describes what a build produced
+type BuildResult struct {
+	Image string
+}
+
+// ErrNotRunning is returned by Adopt when the process is gone
+var ErrNotRunning = errors.New("process is not running")
+
+// Process is a running function started by a runtime
+type Process struct {
+	Name string
+	// Cmd is nil for adopted processes
+	Cmd *exec.Cmd
+	// Output yields the combined stdout/stderr of the function. It is nil
+	// for adopted processes, whose output went to the previous backend.
+	Output io.ReadCloser
+	// Port is set when the runtime knows the port up front. When empty, the
+	// port has to be detected from Output.
+	Port string
+
+	process *os.Process
+	done    chan struct{}
+	err     error
+	once    sync.Once
+}
+
+func newProcess(name string, cmd *exec.Cmd, output io.ReadCloser) *Process {
+	p := &Process{
+		Name:    name,
+		Cmd:     cmd,
+		Output:  output,
+		process: cmd.Process,
+		done:    make(chan struct{}),
+	}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+	return p
+}
+
+// Done is closed once the process has exited
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the exit error of the process once Done is closed
+func (p *Process) Err() error {
+	<-p.done
+	return p.err
+}
+
+// Pid returns the operating system process ID
+func (p *Process) Pid() int {
+	if p.process == nil {
+		return 0
+	}
+	return p.process.Pid
+}
+
+// adoptProcess attaches to a process of fn that is not a child of the
+// backend, such as one left running by a previous instance. It returns
+// ErrNotRunning unless pid is alive and runs in fn.Dir, which guards against
+// the PID having been reused by an unrelated process.
+func adoptProcess(fn Function, pid int, port string) (*Process, error) {
+	if pid <= 0 || !alive(pid) {
+		return nil, ErrNotRunning
+	}
+	if cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid)); err == nil {
+		dir, err := filepath.Abs(fn.Dir)
+		if err != nil || cwd != dir {
+			return nil, ErrNotRunning
+		}
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+
+	p := &Process{Name: fn.Name, Port: port, process: proc, done: make(chan struct{})}
+	// The process can't be waited for since it is not our child, so poll
+	// until it is gone
+	go func() {
+		for alive(pid) {
+			time.Sleep(time.Second)
+		}
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// commandContext is like exec.CommandContext, except that cancelling ctx
+// kills the command's whole process group, so that processes it spawned,
+// such as pip under sh, do not outlive it
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// traceCommand starts a span for a subprocess of fn in the trace of ctx.
+// It is ended by endCommand.
+func traceCommand(ctx context.Context, fn Function, cmd *exec.Cmd) trace.Span {
+	_, span := tracing.Start(ctx, "exec "+filepath.Base(cmd.Path), trace.WithAttributes(
+		attribute.String("deployment", fn.Name),
+		attribute.String("process.executable.name", filepath.Base(cmd.Path)),
+		attribute.StringSlice("process.command_args", cmd.Args),
+	))
+	return span
+}
+
+// endCommand records the process ID and exit code of cmd, once it has run,
+// on its span
+func endCommand(span trace.Span, cmd *exec.Cmd, err error) {
+	if cmd.Process != nil {
+		span.SetAttributes(attribute.Int("process.pid", cmd.Process.Pid))
+	}
+	if cmd.ProcessState != nil {
+		span.SetAttributes(attribute.Int("process.exit_code", cmd.ProcessState.ExitCode()))
+	}
+	tracing.End(span, err)
+}
+
+// alive reports whether a process we may signal exists
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func (p *Process) closeOutput() {
+	p.once.Do(func() {
+		if p.Output != nil {
+			p.Output.Close()
+		}
+	})
+}
+
+// FunctionRuntime creates, builds and runs functions
+type FunctionRuntime interface {
+	// Create scaffolds a new function in fn.Dir
+	Create(ctx context.Context, fn Function) ([]byte, error)
+	// Build builds the function in fn.Dir, streaming its output to out
+	Build(ctx context.Context, fn Function, out io.Writer) (*BuildResult, error)
+	// Run starts the function and returns without waiting for it to exit
+	Run(ctx context.Context, fn Function) (*Process, error)
+	// AcceptsPort reports whether Run listens on fn.Port. Other runtimes
+	// pick a port themselves, which is detected from the function output.
+	AcceptsPort() bool
+	// Detachable reports whether processes started by Run keep running
+	// after the backend exits, so that the next backend can adopt them
+	Detachable() bool
+	// Stop stops a process started by Run or Adopt and waits for it to exit
+	Stop(p *Process) error
+	// Adopt attaches to the process pid of fn left running by a previous
+	// backend, or returns ErrNotRunning if it is gone
+	Adopt(fn Function, pid int, port string) (*Process, error)
+	// Status reports whether a process is still running
+	Status(p *Process) Status
+}
+
+// New returns the runtime selected in the configuration
+func New(cfg *config.Config) (FunctionRuntime, error) {
+	switch cfg.Function.Runtime {
+	case "", "knative":
+		return NewKnative(cfg), nil
+	case "native":
+		return NewNative(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown function runtime: %s", cfg.Function.Runtime)
+	}
+}
+
+func processStatus(p *Process) Status {
+	select {
+	case <-p.Done():
+		return StatusExited
+	default:
+		return StatusRunning
+	}
+}
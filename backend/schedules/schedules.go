@@ -0,0 +1,386 @@
+package schedules
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// maxResponse is how much of each response body is kept with a run
+const maxResponse = 1024
+
+// maxRuns is the number of runs kept per schedule
+const maxRuns = 100
+
+// Schedule invokes a deployment's function whenever its cron expression
+// fires
+type Schedule struct {
+	ID         string `json:"id"`
+	Deployment string `json:"deployment"`
+	// Cron is a five-field cron expression or a descriptor such as @daily
+	// or @every 1h, optionally prefixed with CRON_TZ=<zone>
+	Cron      string `json:"cron"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Body      string `json:"body,omitempty"`
+	Enabled   bool   `json:"enabled"`
+	CreatedAt string `json:"createdAt"`
+	NextRunAt string `json:"nextRunAt,omitempty"`
+}
+
+// Run records one invocation of a schedule
+type Run struct {
+	ID         int64  `json:"id"`
+	ScheduleID string `json:"scheduleId"`
+	StartedAt  string `json:"startedAt"`
+	StatusCode int    `json:"statusCode"`
+	LatencyMs  int64  `json:"latencyMs"`
+	// Response is the start of the response body
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Invoker calls the function of a schedule. The scheduler closes the
+// response body.
+type Invoker func(ctx context.Context, s Schedule) (*http.Response, error)
+
+// Scheduler runs schedules and keeps them and their run history in SQLite
+type Scheduler struct {
+	db      *sql.DB
+	invoke  Invoker
+	timeout time.Duration
+	cron    *cron.Cron
+
+	mu      sync.Mutex
+	entries map[string]cron.EntryID
+}
+
+// NewScheduler returns a scheduler that gives up on runs that take longer
+// than timeout. A schedule whose previous run is still in progress skips
+// its turn.
+func NewScheduler(db *sql.DB, timeout time.Duration) *Scheduler {
+	logger := slogLogger{}
+	return &Scheduler{
+		db:      db,
+		timeout: timeout,
+		cron:    cron.New(cron.WithChain(cron.Recover(logger), cron.SkipIfStillRunning(logger))),
+		entries: make(map[string]cron.EntryID),
+	}
+}
+
+// slogLogger is a cron.Logger that logs through slog. cron passes its
+// attributes as alternating keys and values, as slog does.
+type slogLogger struct{}
+
+func (slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	slog.Info("Scheduler: "+msg, keysAndValues...)
+}
+
+func (slogLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	slog.Error("Scheduler: "+msg, append(keysAndValues, "error", err)...)
+}
+
+// Start runs every enabled schedule, calling invoke for each run
+func (s *Scheduler) Start(invoke Invoker) error {
+	s.invoke = invoke
+	list, err := s.query(context.Background(), "WHERE enabled = 1")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, sc := range list {
+		if err := s.add(sc); err != nil {
+			slog.Error("Error scheduling", "deployment", sc.Deployment, "schedule", sc.ID, "error", err)
+		}
+	}
+	s.mu.Unlock()
+	s.cron.Start()
+	return nil
+}
+
+// Stop stops scheduling runs. The returned context is done once the runs in
+// progress have finished.
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
+
+// Validate checks a schedule and fills in the default method and path
+func Validate(sc *Schedule) error {
+	if _, err := cron.ParseStandard(sc.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression: %v", err)
+	}
+	if sc.Method == "" {
+		sc.Method = http.MethodPost
+	}
+	sc.Method = strings.ToUpper(sc.Method)
+	if sc.Path == "" {
+		sc.Path = "/"
+	}
+	if !strings.HasPrefix(sc.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	return nil
+}
+
+// Create stores and schedules a new schedule
+func (s *Scheduler) Create(ctx context.Context, sc Schedule) (*Schedule, error) {
+	if err := Validate(&sc); err != nil {
+		return nil, err
+	}
+	sc.ID = uuid.New().String()
+	sc.CreatedAt = time.Now().Format(time.RFC3339)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO schedules (id, deployment_name, cron, method, path, body, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, sc.ID, sc.Deployment, sc.Cron, sc.Method, sc.Path, sc.Body, sc.Enabled, sc.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schedule: %v", err)
+	}
+	if err := s.add(sc); err != nil {
+		return nil, err
+	}
+	sc.NextRunAt = s.next(sc.ID)
+	return &sc, nil
+}
+
+// Update replaces the cron expression, request and enabled flag of a
+// schedule. It returns nil if the schedule does not exist.
+func (s *Scheduler) Update(ctx context.Context, sc Schedule) (*Schedule, error) {
+	if err := Validate(&sc); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE schedules
+		SET cron = ?, method = ?, path = ?, body = ?, enabled = ?
+		WHERE id = ? AND deployment_name = ?
+	`, sc.Cron, sc.Method, sc.Path, sc.Body, sc.Enabled, sc.ID, sc.Deployment)
+	if err != nil {
+		return nil, fmt.Errorf("error updating schedule: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	updated, err := s.get(ctx, sc.Deployment, sc.ID)
+	if err != nil || updated == nil {
+		return updated, err
+	}
+	if err := s.add(*updated); err != nil {
+		return nil, err
+	}
+	updated.NextRunAt = s.next(sc.ID)
+	return updated, nil
+}
+
+// Get retrieves a schedule of a deployment, or nil if it does not exist
+func (s *Scheduler) Get(ctx context.Context, deployment, id string) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, err := s.get(ctx, deployment, id)
+	if err != nil || sc == nil {
+		return sc, err
+	}
+	sc.NextRunAt = s.next(id)
+	return sc, nil
+}
+
+func (s *Scheduler) get(ctx context.Context, deployment, id string) (*Schedule, error) {
+	list, err := s.query(ctx, "WHERE id = ? AND deployment_name = ?", id, deployment)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// List returns the schedules of a deployment, oldest first
+func (s *Scheduler) List(ctx context.Context, deployment string) ([]Schedule, error) {
+	list, err := s.query(ctx, "WHERE deployment_name = ? ORDER BY created_at, rowid", deployment)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	for i := range list {
+		list[i].NextRunAt = s.next(list[i].ID)
+	}
+	s.mu.Unlock()
+	return list, nil
+}
+
+// Delete removes a schedule and its run history. It reports whether the
+// schedule existed.
+func (s *Scheduler) Delete(ctx context.Context, deployment, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.db.ExecContext(ctx, "DELETE FROM schedules WHERE id = ? AND deployment_name = ?", id, deployment)
+	if err != nil {
+		return false, fmt.Errorf("error deleting schedule: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	s.remove(id)
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM schedule_runs WHERE schedule_id = ?", id); err != nil {
+		return true, fmt.Errorf("error deleting schedule runs: %v", err)
+	}
+	return true, nil
+}
+
+// DeleteAll removes the schedules of a deployment
+func (s *Scheduler) DeleteAll(ctx context.Context, deployment string) error {
+	list, err := s.List(ctx, deployment)
+	if err != nil {
+		return err
+	}
+	for _, sc := range list {
+		if _, err := s.Delete(ctx, deployment, sc.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs returns the most recent runs of a schedule, newest first
+func (s *Scheduler) Runs(ctx context.Context, id string, limit int) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, schedule_id, started_at, status_code, latency_ms, response, error
+		FROM schedule_runs
+		WHERE schedule_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying schedule runs: %v", err)
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.ID, &r.ScheduleID, &r.StartedAt, &r.StatusCode, &r.LatencyMs, &r.Response, &r.Error); err != nil {
+			return nil, fmt.Errorf("error scanning schedule run: %v", err)
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schedule runs: %v", err)
+	}
+	return runs, nil
+}
+
+// add registers a schedule with cron, replacing any previous registration.
+// s.mu must be held.
+func (s *Scheduler) add(sc Schedule) error {
+	s.remove(sc.ID)
+	if !sc.Enabled {
+		return nil
+	}
+	id, err := s.cron.AddFunc(sc.Cron, func() { s.run(sc) })
+	if err != nil {
+		return fmt.Errorf("error scheduling: %v", err)
+	}
+	s.entries[sc.ID] = id
+	return nil
+}
+
+// remove unregisters a schedule. s.mu must be held.
+func (s *Scheduler) remove(id string) {
+	if entry, ok := s.entries[id]; ok {
+		s.cron.Remove(entry)
+		delete(s.entries, id)
+	}
+}
+
+// next returns when a schedule runs next. s.mu must be held.
+func (s *Scheduler) next(id string) string {
+	entry, ok := s.entries[id]
+	if !ok {
+		return ""
+	}
+	if next := s.cron.Entry(entry).Next; !next.IsZero() {
+		return next.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// run invokes the function of a schedule and records the outcome
+func (s *Scheduler) run(sc Schedule) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	startedAt := time.Now()
+	run := Run{ScheduleID: sc.ID, StartedAt: startedAt.Format(time.RFC3339)}
+	resp, err := s.invoke(ctx, sc)
+	if err == nil {
+		run.StatusCode = resp.StatusCode
+		body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+		resp.Body.Close()
+		run.Response = string(body)
+		err = readErr
+	}
+	run.LatencyMs = time.Since(startedAt).Milliseconds()
+	if err != nil {
+		run.Error = err.Error()
+		slog.Warn("Scheduled run failed", "deployment", sc.Deployment, "schedule", sc.ID, "error", err)
+	}
+
+	// The run is recorded even if it timed out
+	ctx = context.WithoutCancel(ctx)
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO schedule_runs (schedule_id, started_at, status_code, latency_ms, response, error)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, run.ScheduleID, run.StartedAt, run.StatusCode, run.LatencyMs, run.Response, run.Error)
+	if err != nil {
+		slog.Error("Error recording schedule run", "deployment", sc.Deployment, "schedule", sc.ID, "error", err)
+		return
+	}
+	// Keep only the most recent runs
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM schedule_runs
+		WHERE schedule_id = ? AND id NOT IN (
+			SELECT id FROM schedule_runs WHERE schedule_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, sc.ID, sc.ID, maxRuns)
+	if err != nil {
+		slog.Error("Error pruning schedule runs", "deployment", sc.Deployment, "schedule", sc.ID, "error", err)
+	}
+}
+
+func (s *Scheduler) query(ctx context.Context, where string, args ...interface{}) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, deployment_name, cron, method, path, body, enabled, created_at
+		FROM schedules
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying schedules: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Schedule{}
+	for rows.Next() {
+		var sc Schedule
+		err := rows.Scan(&sc.ID, &sc.Deployment, &sc.Cron, &sc.Method, &sc.Path, &sc.Body, &sc.Enabled, &sc.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning schedule: %v", err)
+		}
+		list = append(list, sc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating schedules: %v", err)
+	}
+	return list, nil
+}
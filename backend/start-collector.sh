@@ -0,0 +1,29 @@
+#!/bin/bash
+
+# Check if Docker is running
+if ! docker info > /dev/null 2>&1; then
+    echo "Docker is not running. Please start Docker and try again."
+    exit 1
+fi
+
+# Check if collector container exists
+if ! docker ps -a | grep -q "local-collector"; then
+    echo "Creating local collector container..."
+    docker run -d \
+        -p 4318:4318 \
+        -p 16686:16686 \
+        -e COLLECTOR_OTLP_ENABLED=true \
+        --name local-collector \
+        --restart=always \
+        jaegertracing/all-in-one:1.57
+else
+    # Check if collector container is running
+    if ! docker ps | grep -q "local-collector"; then
+        echo "Starting local collector container..."
+        docker start local-collector
+    else
+        echo "Local collector is already running."
+    fi
+fi
+
+echo "Local collector is receiving OTLP/HTTP at localhost:4318, traces are shown at http://localhost:16686"
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"main/db"
+	"main/types"
+)
+
+// Status is the lifecycle state of a deployment
+type Status string
+
+const (
+	Creating Status = "Creating"
+	Stopped  Status = "Stopped"
+	Building Status = "Building"
+	Starting Status = "Starting"
+	Running  Status = "Running"
+	Failed   Status = "Failed"
+	// Unhealthy is a running function that is failing its health checks
+	Unhealthy Status = "Unhealthy"
+	// Crashed is a function whose process exited while it was running
+	Crashed Status = "Crashed"
+)
+
+// transitions lists the statuses each status may move to
+var transitions = map[Status][]Status{
+	Creating:  {Stopped, Failed},
+	Stopped:   {Building, Starting},
+	Failed:    {Building, Starting, Stopped},
+	Building:  {Stopped, Failed},
+	Starting:  {Running, Stopped, Failed},
+	Running:   {Unhealthy, Crashed, Stopped, Failed},
+	Unhealthy: {Running, Crashed, Stopped, Failed},
+	Crashed:   {Building, Starting, Stopped},
+}
+
+// ErrIllegalTransition is matched by the errors returned for transitions
+// the state machine does not allow
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// TransitionError describes a rejected transition
+type TransitionError struct {
+	Deployment string
+	From, To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change status of %s from %s to %s", e.Deployment, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// Of returns the status of a deployment
+func Of(d *types.Deployment) Status {
+	return Status(d.Status)
+}
+
+// Up reports whether a deployment's function process is running, healthy or
+// not
+func Up(d *types.Deployment) bool {
+	return Of(d) == Running || Of(d) == Unhealthy
+}
+
+// CanTransition reports whether a deployment may move from one status to
+// another
+func CanTransition(from, to Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns a TransitionError if the deployment may not move to status
+// to from its current status
+func Check(d *types.Deployment, to Status) error {
+	if !CanTransition(Of(d), to) {
+		return &TransitionError{Deployment: d.Name, From: Of(d), To: to}
+	}
+	return nil
+}
+
+// Machine changes deployment statuses. It validates every transition
+// against the stored status and records it in the deployment's history.
+type Machine struct {
+	store db.DeploymentStore
+	// mu serializes transitions so that each one sees the status left by
+	// the previous one
+	mu sync.Mutex
+}
+
+func NewMachine(store db.DeploymentStore) *Machine {
+	return &Machine{store: store}
+}
+
+// Create stores a new deployment in the Creating status
+func (m *Machine) Create(ctx context.Context, d *types.Deployment, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.store.WithinTx(ctx, func(tx db.DeploymentStore) error {
+		created := *d
+		created.Status = string(Creating)
+		if err := tx.Create(ctx, created); err != nil {
+			return err
+		}
+		return tx.RecordTransition(ctx, newTransition(d.Name, "", Creating, reason))
+	})
+	if err != nil {
+		return err
+	}
+	d.Status = string(Creating)
+	return nil
+}
+
+// Transition moves a deployment to status to, saving any other changes made
+// to d in the same transaction. It returns a TransitionError if the move is
+// not allowed from the deployment's stored status.
+func (m *Machine) Transition(ctx context.Context, d *types.Deployment, to Status, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.store.WithinTx(ctx, func(tx db.DeploymentStore) error {
+		current, err := tx.Get(ctx, d.Name)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return fmt.Errorf("deployment %s not found", d.Name)
+		}
+		if err := Check(current, to); err != nil {
+			return err
+		}
+		if err := tx.Update(ctx, *d); err != nil {
+			return err
+		}
+		return tx.RecordTransition(ctx, newTransition(d.Name, Of(current), to, reason))
+	})
+	if err != nil {
+		return err
+	}
+	d.Status = string(to)
+	return nil
+}
+
+// History returns the most recent transitions of a deployment, newest first
+func (m *Machine) History(ctx context.Context, name string, limit int) ([]types.StatusTransition, error) {
+	return m.store.Transitions(ctx, name, limit)
+}
+
+func newTransition(name string, from, to Status, reason string) types.StatusTransition {
+	return types.StatusTransition{
+		Deployment: name,
+		From:       string(from),
+		To:         string(to),
+		Reason:     reason,
+		At:         time.Now().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,241 @@
+package topics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// namePattern restricts topic names, for example orders.created
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// ErrExists is returned when subscribing a deployment to a topic path it is
+// already subscribed to
+var ErrExists = errors.New("deployment is already subscribed to this topic and path")
+
+// Subscription delivers the events published to a topic to a path on a
+// deployment's function
+type Subscription struct {
+	ID         string  `json:"id"`
+	Topic      string  `json:"topic"`
+	Deployment string  `json:"deployment"`
+	Path       string  `json:"path"`
+	CreatedAt  string  `json:"createdAt"`
+	Metrics    Metrics `json:"metrics"`
+}
+
+// Metrics counts the deliveries of a subscription
+type Metrics struct {
+	// Published is the number of events queued for the subscription
+	Published int64 `json:"published"`
+	Delivered int64 `json:"delivered"`
+	// FailedAttempts counts every failed attempt, including those that
+	// were retried successfully
+	FailedAttempts int64 `json:"failedAttempts"`
+	DeadLettered   int64 `json:"deadLettered"`
+	// AvgLatencyMs is the average latency of successful deliveries
+	AvgLatencyMs    int64  `json:"avgLatencyMs"`
+	LastDeliveredAt string `json:"lastDeliveredAt,omitempty"`
+	LastError       string `json:"lastError,omitempty"`
+}
+
+// Topic summarizes a topic that has subscriptions
+type Topic struct {
+	Name          string `json:"name"`
+	Subscriptions int    `json:"subscriptions"`
+}
+
+// Store keeps topic subscriptions and their metrics in SQLite
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// ValidateName checks a topic name
+func ValidateName(topic string) error {
+	if !namePattern.MatchString(topic) {
+		return errors.New("topic names must start with a letter or digit and consist of letters, digits, dots, dashes and underscores")
+	}
+	return nil
+}
+
+// Subscribe subscribes a deployment to a topic. Events are delivered to
+// path, which defaults to /.
+func (s *Store) Subscribe(ctx context.Context, topic, deployment, path string) (*Subscription, error) {
+	if err := ValidateName(topic); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	existing, err := s.query(ctx, "WHERE topic = ? AND deployment_name = ? AND path = ?", topic, deployment, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, ErrExists
+	}
+
+	sub := &Subscription{
+		ID:         uuid.New().String(),
+		Topic:      topic,
+		Deployment: deployment,
+		Path:       path,
+		CreatedAt:  time.Now().Format(time.RFC3339),
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO topic_subscriptions (id, topic, deployment_name, path, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, sub.ID, sub.Topic, sub.Deployment, sub.Path, sub.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating subscription: %v", err)
+	}
+	return sub, nil
+}
+
+// Get retrieves a subscription of a topic, or nil if it does not exist
+func (s *Store) Get(ctx context.Context, topic, id string) (*Subscription, error) {
+	list, err := s.query(ctx, "WHERE topic = ? AND id = ?", topic, id)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// Subscriptions returns the subscriptions of a topic, oldest first
+func (s *Store) Subscriptions(ctx context.Context, topic string) ([]Subscription, error) {
+	return s.query(ctx, "WHERE topic = ? ORDER BY created_at, rowid", topic)
+}
+
+// ForDeployment returns the subscriptions of a deployment, oldest first
+func (s *Store) ForDeployment(ctx context.Context, deployment string) ([]Subscription, error) {
+	return s.query(ctx, "WHERE deployment_name = ? ORDER BY created_at, rowid", deployment)
+}
+
+// Topics returns the topics that have subscriptions, by name
+func (s *Store) Topics(ctx context.Context) ([]Topic, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT topic, COUNT(*) FROM topic_subscriptions GROUP BY topic ORDER BY topic
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying topics: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Topic{}
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.Name, &t.Subscriptions); err != nil {
+			return nil, fmt.Errorf("error scanning topic: %v", err)
+		}
+		list = append(list, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating topics: %v", err)
+	}
+	return list, nil
+}
+
+// Unsubscribe removes a subscription. Events already queued for it are
+// still delivered. It reports whether the subscription existed.
+func (s *Store) Unsubscribe(ctx context.Context, topic, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM topic_subscriptions WHERE topic = ? AND id = ?", topic, id)
+	if err != nil {
+		return false, fmt.Errorf("error deleting subscription: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteAll removes the subscriptions of a deployment
+func (s *Store) DeleteAll(ctx context.Context, deployment string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM topic_subscriptions WHERE deployment_name = ?", deployment); err != nil {
+		return fmt.Errorf("error deleting subscriptions: %v", err)
+	}
+	return nil
+}
+
+// RecordPublished counts an event queued for each of the subscriptions
+func (s *Store) RecordPublished(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		if _, err := s.db.ExecContext(ctx, "UPDATE topic_subscriptions SET published = published + 1 WHERE id = ?", id); err != nil {
+			return fmt.Errorf("error updating subscription metrics: %v", err)
+		}
+	}
+	return nil
+}
+
+// RecordDelivered counts a successful delivery to a subscription
+func (s *Store) RecordDelivered(ctx context.Context, id string, latency time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE topic_subscriptions
+		SET delivered = delivered + 1, total_latency_ms = total_latency_ms + ?, last_delivered_at = ?
+		WHERE id = ?
+	`, latency.Milliseconds(), time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		return fmt.Errorf("error updating subscription metrics: %v", err)
+	}
+	return nil
+}
+
+// RecordFailed counts a failed delivery attempt to a subscription, and a
+// dead letter if it was the last attempt
+func (s *Store) RecordFailed(ctx context.Context, id string, dead bool, lastError string) error {
+	deadLettered := 0
+	if dead {
+		deadLettered = 1
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE topic_subscriptions
+		SET failed_attempts = failed_attempts + 1, dead_lettered = dead_lettered + ?, last_error = ?
+		WHERE id = ?
+	`, deadLettered, lastError, id)
+	if err != nil {
+		return fmt.Errorf("error updating subscription metrics: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) query(ctx context.Context, where string, args ...interface{}) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, topic, deployment_name, path, created_at, published, delivered, failed_attempts,
+			dead_lettered, total_latency_ms, last_delivered_at, last_error
+		FROM topic_subscriptions
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		var totalLatency int64
+		m := &sub.Metrics
+		err := rows.Scan(&sub.ID, &sub.Topic, &sub.Deployment, &sub.Path, &sub.CreatedAt, &m.Published, &m.Delivered,
+			&m.FailedAttempts, &m.DeadLettered, &totalLatency, &m.LastDeliveredAt, &m.LastError)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning subscription: %v", err)
+		}
+		if m.Delivered > 0 {
+			m.AvgLatencyMs = totalLatency / m.Delivered
+		}
+		list = append(list, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating subscriptions: %v", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testTraceID = trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	testSpanID  = trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}
+	testParent  = trace.SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8}
+	testStart   = time.Unix(1700000000, 123)
+)
+
+// testSpan returns a finished client span with a parent, an event, a link,
+// an error status and an attribute of every type
+func testSpan() sdktrace.ReadOnlySpan {
+	return tracetest.SpanStub{
+		Name: "db.query",
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: testTraceID, SpanID: testSpanID, TraceFlags: trace.FlagsSampled,
+		}),
+		Parent: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: testTraceID, SpanID: testParent, TraceFlags: trace.FlagsSampled,
+		}),
+		SpanKind:  trace.SpanKindClient,
+		StartTime: testStart,
+		EndTime:   testStart.Add(1500 * time.Microsecond),
+		Attributes: []attribute.KeyValue{
+			attribute.String("db.system", "sqlite"),
+			attribute.Bool("cached", false),
+			attribute.Int64("rows", 9007199254740993),
+			attribute.Float64("ratio", 0.5),
+			attribute.StringSlice("tables", []string{"deployments", "jobs"}),
+			attribute.Int64Slice("ports", []int64{8000, 8001}),
+		},
+		Events: []sdktrace.Event{{
+			Name:       "exception",
+			Time:       testStart.Add(time.Millisecond),
+			Attributes: []attribute.KeyValue{attribute.String("exception.message", "disk I/O error")},
+		}},
+		Links: []sdktrace.Link{{
+			SpanContext: trace.NewSpanContext(trace.SpanContextConfig{TraceID: testTraceID, SpanID: testParent}),
+		}},
+		Status:                 sdktrace.Status{Code: codes.Error, Description: "disk I/O error"},
+		Resource:               resource.NewSchemaless(attribute.String("service.name", serviceName)),
+		InstrumentationLibrary: instrumentation.Library{Name: "main", Version: "1.0.0"},
+	}.Snapshot()
+}
+
+// The expected request follows the OTLP/JSON encoding: trace and span IDs
+// are hex, 64-bit integers and timestamps are decimal strings, and kind and
+// status code are the numeric enum values (3 is SPAN_KIND_CLIENT, 2 is
+// STATUS_CODE_ERROR).
+const wantRequest = `{
+	"resourceSpans": [{
+		"resource": {
+			"attributes": [{"key": "service.name", "value": {"stringValue": "serverless"}}]
+		},
+		"scopeSpans": [{
+			"scope": {"name": "main", "version": "1.0.0"},
+			"spans": [{
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId": "00f067aa0ba902b7",
+				"parentSpanId": "53995c3f42cd8ad8",
+				"name": "db.query",
+				"kind": 3,
+				"startTimeUnixNano": "1700000000000000123",
+				"endTimeUnixNano": "1700000000001500123",
+				"attributes": [
+					{"key": "db.system", "value": {"stringValue": "sqlite"}},
+					{"key": "cached", "value": {"boolValue": false}},
+					{"key": "rows", "value": {"intValue": "9007199254740993"}},
+					{"key": "ratio", "value": {"doubleValue": 0.5}},
+					{"key": "tables", "value": {"arrayValue": {"values": [
+						{"stringValue": "deployments"}, {"stringValue": "jobs"}
+					]}}},
+					{"key": "ports", "value": {"arrayValue": {"values": [
+						{"intValue": "8000"}, {"intValue": "8001"}
+					]}}}
+				],
+				"events": [{
+					"timeUnixNano": "1700000000001000123",
+					"name": "exception",
+					"attributes": [{"key": "exception.message", "value": {"stringValue": "disk I/O error"}}]
+				}],
+				"links": [{
+					"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+					"spanId": "53995c3f42cd8ad8"
+				}],
+				"status": {"code": 2, "message": "disk I/O error"}
+			}]
+		}]
+	}]
+}`
+
+// assertJSON fails the test unless got and want encode the same JSON value
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("error decoding %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("error decoding expected JSON: %v", err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestEncodeSpans(t *testing.T) {
+	got, err := json.Marshal(encodeSpans([]sdktrace.ReadOnlySpan{testSpan()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSON(t, got, wantRequest)
+}
+
+func TestEncodeStatus(t *testing.T) {
+	for _, tt := range []struct {
+		status sdktrace.Status
+		want   string
+	}{
+		// Unset is the zero value and is left out
+		{sdktrace.Status{Code: codes.Unset}, `{}`},
+		{sdktrace.Status{Code: codes.Ok}, `{"code": 1}`},
+		{sdktrace.Status{Code: codes.Error, Description: "failed"}, `{"code": 2, "message": "failed"}`},
+	} {
+		span := encodeSpan(tracetest.SpanStub{Status: tt.status}.Snapshot())
+		got, err := json.Marshal(span.Status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, got, tt.want)
+	}
+}
+
+func TestEncodeSpansGroupsScopes(t *testing.T) {
+	var spans []sdktrace.ReadOnlySpan
+	for _, scope := range []string{"main", "db", "main"} {
+		spans = append(spans, tracetest.SpanStub{
+			Name:                   scope + " span",
+			InstrumentationLibrary: instrumentation.Library{Name: scope},
+		}.Snapshot())
+	}
+	req := encodeSpans(spans)
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("got %d resource spans, want 1", len(req.ResourceSpans))
+	}
+	scopes := req.ResourceSpans[0].ScopeSpans
+	if len(scopes) != 2 || scopes[0].Scope.Name != "main" || scopes[1].Scope.Name != "db" {
+		t.Fatalf("unexpected scopes %+v", scopes)
+	}
+	if len(scopes[0].Spans) != 2 || len(scopes[1].Spans) != 1 {
+		t.Fatalf("got %d and %d spans, want 2 and 1", len(scopes[0].Spans), len(scopes[1].Spans))
+	}
+	// Root spans have no parent span ID
+	if scopes[0].Spans[0].ParentSpanID != "" {
+		t.Fatalf("unexpected parent span ID %q", scopes[0].Spans[0].ParentSpanID)
+	}
+}
+
+func TestExportSpans(t *testing.T) {
+	var path, contentType string
+	var body []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	e, err := NewOTLPExporter(collector.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{testSpan()}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/traces" {
+		t.Fatalf("posted to %s, want /v1/traces", path)
+	}
+	if contentType != "application/json" {
+		t.Fatalf("got Content-Type %q, want application/json", contentType)
+	}
+	assertJSON(t, body, wantRequest)
+}
+
+func TestExportSpansCollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid span", http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	e, err := NewOTLPExporter(collector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{testSpan()})
+	if err == nil || !strings.Contains(err.Error(), "invalid span") {
+		t.Fatalf("got error %v, want the collector's response", err)
+	}
+}
+
+func TestNewOTLPExporterInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"", "localhost:4318", "ftp://localhost:4318"} {
+		if _, err := NewOTLPExporter(endpoint); err == nil {
+			t.Errorf("NewOTLPExporter(%q) succeeded, want an error", endpoint)
+		}
+	}
+}
@@ -9,11 +9,55 @@ type Deployment struct {
 	CreatedAt string `json:"createdAt"`
 	Port      string `json:"port,omitempty"` // Store the port if running
 	Built     bool   `json:"built"`
+	PID       int    `json:"pid,omitempty"` // Process ID of the running function
+	// RestartPolicy is "never", "on-failure" or "always", or empty for the
+	// configured default
+	RestartPolicy string      `json:"restartPolicy,omitempty"`
+	HealthCheck   HealthCheck `json:"healthCheck"`
+}
+
+// HealthCheck configures how a running function is probed. Empty and zero
+// fields fall back to the configured defaults.
+type HealthCheck struct {
+	// Type is "http", "tcp" or "none"
+	Type string `json:"type,omitempty"`
+	// Path is requested by HTTP checks, which pass on a 2xx or 3xx response
+	Path            string `json:"path,omitempty"`
+	IntervalSeconds int    `json:"intervalSeconds,omitempty"`
+	TimeoutSeconds  int    `json:"timeoutSeconds,omitempty"`
+	// FailureThreshold is the number of consecutive failed checks after
+	// which the function is unhealthy
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+}
+
+// ColdStart records a function being started on demand by an invocation
+type ColdStart struct {
+	StartedAt  string `json:"startedAt"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// StatusTransition records a change of a deployment's status
+type StatusTransition struct {
+	Deployment string `json:"deployment"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Reason     string `json:"reason"`
+	At         string `json:"at"`
 }
 
 // DeploymentDetail includes the deployment metadata plus code and package content
 type DeploymentDetail struct {
 	Deployment
-	Code    string `json:"code,omitempty"`
-	Package string `json:"package,omitempty"`
+	Code       string      `json:"code,omitempty"`
+	Package    string      `json:"package,omitempty"`
+	ColdStarts []ColdStart `json:"coldStarts,omitempty"`
+	Env        []EnvVar    `json:"env,omitempty"`
+}
+
+// EnvVar is an environment variable passed to a deployment's build and run
+// commands. Secret values are encrypted at rest and never returned by the API.
+type EnvVar struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret"`
 }
@@ -0,0 +1,393 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSignatureHeader carries the signature of signed webhooks unless
+// another header is configured. It is the header GitHub uses.
+const DefaultSignatureHeader = "X-Hub-Signature-256"
+
+// MaxResponse is how much of each response body is kept with a delivery
+const MaxResponse = 1024
+
+// maxDeliveries is the number of deliveries kept per webhook
+const maxDeliveries = 100
+
+// ErrInvalidSignature is returned when a signed webhook receives a request
+// without a valid signature
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Webhook forwards requests sent to its secret URL to a deployment's
+// function
+type Webhook struct {
+	ID         string `json:"id"`
+	Deployment string `json:"deployment"`
+	// Token is the secret part of the webhook's URL, /hooks/{token}
+	Token string `json:"token"`
+	// Path is the function path requests are forwarded to
+	Path string `json:"path"`
+	// Signed webhooks only accept requests carrying an HMAC-SHA256
+	// signature of the body in SignatureHeader. The secret is never
+	// returned after the webhook is created.
+	Signed          bool   `json:"signed"`
+	SignatureHeader string `json:"signatureHeader,omitempty"`
+	CreatedAt       string `json:"createdAt"`
+
+	secret string
+}
+
+// Delivery records one request received by a webhook
+type Delivery struct {
+	ID         int64  `json:"id"`
+	WebhookID  string `json:"webhookId"`
+	ReceivedAt string `json:"receivedAt"`
+	Method     string `json:"method"`
+	// Headers are those of the request without its credentials, see
+	// StoredHeaders
+	Headers http.Header `json:"headers"`
+	Query   string      `json:"query,omitempty"`
+	// Body is only filled in when a single delivery is retrieved
+	Body       []byte `json:"-"`
+	BodySHA256 string `json:"bodySha256"`
+	// Verified is set when the request carried a valid signature
+	Verified   bool  `json:"verified"`
+	StatusCode int   `json:"statusCode"`
+	LatencyMs  int64 `json:"latencyMs"`
+	// Response is the start of the function's response body
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+	// ReplayOf is the delivery this one replayed
+	ReplayOf *int64 `json:"replayOf,omitempty"`
+}
+
+// Secrets encrypts signing secrets at rest. envvars.Store implements it
+// with the secrets key.
+type Secrets interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(encoded string) (string, error)
+}
+
+// Store keeps webhooks and their deliveries in SQLite. Signing secrets are
+// encrypted with secrets before they are written.
+type Store struct {
+	db      *sql.DB
+	secrets Secrets
+}
+
+// NewStore returns a store backed by the webhooks tables
+func NewStore(db *sql.DB, secrets Secrets) *Store {
+	return &Store{db: db, secrets: secrets}
+}
+
+// Verify checks an HMAC-SHA256 signature of body made with secret. The
+// signature is hex encoded, optionally prefixed with "sha256=".
+func Verify(secret string, body []byte, signature string) error {
+	sig, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if err != nil || len(sig) == 0 {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sign returns the signature Verify expects for body, in the
+// "sha256=<hex>" form
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a request to the webhook. Unsigned
+// webhooks accept every request.
+func (wh *Webhook) Verify(body []byte, header http.Header) error {
+	if !wh.Signed {
+		return nil
+	}
+	return Verify(wh.secret, body, header.Get(wh.SignatureHeader))
+}
+
+// credentialHeaders are left out of stored deliveries, as they may
+// authenticate the sender to the function
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// StoredHeaders returns the headers of a request to the webhook as they are
+// stored with its delivery: without credentials or the signature, which
+// are only forwarded with the request itself
+func (wh *Webhook) StoredHeaders(header http.Header) http.Header {
+	stored := header.Clone()
+	for _, k := range credentialHeaders {
+		stored.Del(k)
+	}
+	if wh.SignatureHeader != "" {
+		stored.Del(wh.SignatureHeader)
+	}
+	return stored
+}
+
+// Validate checks a webhook and fills in the default path and signature
+// header
+func Validate(wh *Webhook) error {
+	if wh.Path == "" {
+		wh.Path = "/"
+	}
+	if !strings.HasPrefix(wh.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	if wh.SignatureHeader == "" {
+		wh.SignatureHeader = DefaultSignatureHeader
+	}
+	return nil
+}
+
+// GenerateSecret returns a random signing secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating secret: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create stores a new webhook with a random token. If secret is not empty
+// the webhook only accepts requests signed with it, and Create returns
+// envvars.ErrNoKey while no secrets key is configured.
+func (s *Store) Create(ctx context.Context, wh Webhook, secret string) (*Webhook, error) {
+	if err := Validate(&wh); err != nil {
+		return nil, err
+	}
+	sealed := ""
+	if secret != "" {
+		var err error
+		if sealed, err = s.secrets.Encrypt(secret); err != nil {
+			return nil, err
+		}
+	}
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("error generating webhook token: %v", err)
+	}
+	wh.ID = uuid.New().String()
+	wh.Token = hex.EncodeToString(token)
+	wh.Signed = secret != ""
+	wh.secret = secret
+	if !wh.Signed {
+		wh.SignatureHeader = ""
+	}
+	wh.CreatedAt = time.Now().Format(time.RFC3339)
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (id, deployment_name, token, path, secret, signature_header, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, wh.ID, wh.Deployment, wh.Token, wh.Path, sealed, wh.SignatureHeader, wh.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating webhook: %v", err)
+	}
+	return &wh, nil
+}
+
+// Get retrieves a webhook of a deployment, or nil if it does not exist
+func (s *Store) Get(ctx context.Context, deployment, id string) (*Webhook, error) {
+	return s.queryOne(ctx, "WHERE id = ? AND deployment_name = ?", id, deployment)
+}
+
+// GetByToken retrieves the webhook with a token, or nil if there is none
+func (s *Store) GetByToken(ctx context.Context, token string) (*Webhook, error) {
+	return s.queryOne(ctx, "WHERE token = ?", token)
+}
+
+// List returns the webhooks of a deployment, oldest first
+func (s *Store) List(ctx context.Context, deployment string) ([]Webhook, error) {
+	return s.query(ctx, "WHERE deployment_name = ? ORDER BY created_at, rowid", deployment)
+}
+
+// Delete removes a webhook and its deliveries. It reports whether the
+// webhook existed.
+func (s *Store) Delete(ctx context.Context, deployment, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND deployment_name = ?", id, deployment)
+	if err != nil {
+		return false, fmt.Errorf("error deleting webhook: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return true, fmt.Errorf("error deleting webhook deliveries: %v", err)
+	}
+	return true, nil
+}
+
+// DeleteAll removes the webhooks of a deployment and their deliveries,
+// without decrypting their secrets
+func (s *Store) DeleteAll(ctx context.Context, deployment string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE webhook_id IN (SELECT id FROM webhooks WHERE deployment_name = ?)
+	`, deployment)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook deliveries: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE deployment_name = ?", deployment); err != nil {
+		return fmt.Errorf("error deleting webhooks: %v", err)
+	}
+	return nil
+}
+
+// RecordDelivery stores a delivery, filling in its ID and body hash, and
+// drops the oldest deliveries of the webhook beyond the most recent ones
+func (s *Store) RecordDelivery(ctx context.Context, d *Delivery) error {
+	headers, err := json.Marshal(d.Headers)
+	if err != nil {
+		return fmt.Errorf("error encoding delivery headers: %v", err)
+	}
+	sum := sha256.Sum256(d.Body)
+	d.BodySHA256 = hex.EncodeToString(sum[:])
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, received_at, method, headers, query, body, body_sha256,
+			verified, status_code, latency_ms, response, error, replay_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.WebhookID, d.ReceivedAt, d.Method, string(headers), d.Query, d.Body, d.BodySHA256,
+		d.Verified, d.StatusCode, d.LatencyMs, d.Response, d.Error, d.ReplayOf)
+	if err != nil {
+		return fmt.Errorf("error recording delivery: %v", err)
+	}
+	d.ID, _ = res.LastInsertId()
+
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE webhook_id = ? AND id NOT IN (
+			SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, d.WebhookID, d.WebhookID, maxDeliveries)
+	if err != nil {
+		return fmt.Errorf("error pruning deliveries: %v", err)
+	}
+	return nil
+}
+
+// Deliveries returns the most recent deliveries of a webhook, newest first,
+// without their bodies
+func (s *Store) Deliveries(ctx context.Context, webhookID string, limit int) ([]Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		d.Body = nil
+		list = append(list, *d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deliveries: %v", err)
+	}
+	return list, nil
+}
+
+// Delivery retrieves a delivery of a webhook with its body, or nil if it
+// does not exist
+func (s *Store) Delivery(ctx context.Context, webhookID string, id int64) (*Delivery, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE webhook_id = ? AND id = ?
+	`, webhookID, id)
+	d, err := scanDelivery(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+const deliveryColumns = `id, webhook_id, received_at, method, headers, query, body, body_sha256,
+	verified, status_code, latency_ms, response, error, replay_of`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*Delivery, error) {
+	var d Delivery
+	var headers string
+	var replayOf sql.NullInt64
+	err := row.Scan(&d.ID, &d.WebhookID, &d.ReceivedAt, &d.Method, &headers, &d.Query, &d.Body, &d.BodySHA256,
+		&d.Verified, &d.StatusCode, &d.LatencyMs, &d.Response, &d.Error, &replayOf)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error scanning delivery: %v", err)
+	}
+	if err := json.Unmarshal([]byte(headers), &d.Headers); err != nil {
+		return nil, fmt.Errorf("error decoding delivery headers: %v", err)
+	}
+	if replayOf.Valid {
+		d.ReplayOf = &replayOf.Int64
+	}
+	return &d, nil
+}
+
+func (s *Store) queryOne(ctx context.Context, where string, args ...interface{}) (*Webhook, error) {
+	list, err := s.query(ctx, where, args...)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+func (s *Store) query(ctx context.Context, where string, args ...interface{}) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, deployment_name, token, path, secret, signature_header, created_at
+		FROM webhooks
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	list := []Webhook{}
+	for rows.Next() {
+		var wh Webhook
+		err := rows.Scan(&wh.ID, &wh.Deployment, &wh.Token, &wh.Path, &wh.secret, &wh.SignatureHeader, &wh.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook: %v", err)
+		}
+		if wh.secret != "" {
+			if wh.secret, err = s.secrets.Decrypt(wh.secret); err != nil {
+				return nil, err
+			}
+		}
+		wh.Signed = wh.secret != ""
+		list = append(list, wh)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhooks: %v", err)
+	}
+	return list, nil
+}
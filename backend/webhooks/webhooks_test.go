@@ -0,0 +1,108 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"main/db"
+	"main/envvars"
+)
+
+func newTestStore(t *testing.T, key string) *Store {
+	t.Helper()
+	conn, err := db.InitDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	secrets, err := envvars.NewStore(conn, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(conn, secrets)
+}
+
+func TestSecretEncryptedAtRest(t *testing.T) {
+	s := newTestStore(t, "test key")
+	wh, err := s.Create(context.Background(), Webhook{Deployment: "hello"}, "signing secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	if err := s.db.QueryRow("SELECT secret FROM webhooks WHERE id = ?", wh.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == "" || stored == "signing secret" {
+		t.Fatalf("secret stored as %q", stored)
+	}
+
+	got, err := s.GetByToken(context.Background(), wh.Token)
+	if err != nil || got == nil {
+		t.Fatalf("got %v, error %v", got, err)
+	}
+	body := []byte(`{"ok":true}`)
+	header := http.Header{DefaultSignatureHeader: {Sign("signing secret", body)}}
+	if err := got.Verify(body, header); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+}
+
+func TestSignedWebhookNeedsKey(t *testing.T) {
+	s := newTestStore(t, "")
+	if _, err := s.Create(context.Background(), Webhook{Deployment: "hello"}, "signing secret"); !errors.Is(err, envvars.ErrNoKey) {
+		t.Fatalf("got error %v, want %v", err, envvars.ErrNoKey)
+	}
+	if _, err := s.Create(context.Background(), Webhook{Deployment: "hello"}, ""); err != nil {
+		t.Fatalf("unsigned webhook: %v", err)
+	}
+}
+
+func TestDeliveryStoredWithoutCredentials(t *testing.T) {
+	s := newTestStore(t, "test key")
+	ctx := context.Background()
+	wh, err := s.Create(ctx, Webhook{Deployment: "hello"}, "signing secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"ok":true}`)
+	header := http.Header{
+		"Authorization":        {"Bearer provider token"},
+		"Cookie":               {"session=1"},
+		DefaultSignatureHeader: {Sign("signing secret", body)},
+		"Content-Type":         {"application/json"},
+	}
+	d := &Delivery{
+		WebhookID: wh.ID,
+		Method:    http.MethodPost,
+		Headers:   wh.StoredHeaders(header),
+		Query:     "event=push&id=1",
+		Body:      body,
+	}
+	if err := s.RecordDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	// The request itself keeps its headers
+	if header.Get("Authorization") == "" {
+		t.Fatal("StoredHeaders modified the request headers")
+	}
+
+	got, err := s.Delivery(ctx, wh.ID, d.ID)
+	if err != nil || got == nil {
+		t.Fatalf("got %v, error %v", got, err)
+	}
+	for _, k := range []string{"Authorization", "Cookie", DefaultSignatureHeader} {
+		if v := got.Headers.Get(k); v != "" {
+			t.Errorf("%s stored as %q", k, v)
+		}
+	}
+	if v := got.Headers.Get("Content-Type"); v != "application/json" {
+		t.Errorf("got Content-Type %q, want it stored", v)
+	}
+	if got.Query != d.Query {
+		t.Errorf("got query %q, want %q", got.Query, d.Query)
+	}
+}
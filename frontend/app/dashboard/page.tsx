@@ -4,11 +4,12 @@ import { useState } from "react";
 import Header from "@/components/Header";
 import DeploymentTable from "@/components/DeploymentTable";
 import { NewDeploymentDialog } from "@/components/NewDeploymentDialog";
-import { useAuth } from "@/utils/auth";
+import { authFetch, useAuth } from "@/utils/auth";
 import { NextPage } from "next";
 import { BACKEND_URL } from "@/lib/utils";
 import { useWebSocket } from "@/utils/websocket";
 import { showErrorAlert } from "@/utils/alert";
+import { isUp } from "@/types";
 
 const Dashboard: NextPage = () => {
   const { deployments } = useWebSocket();
@@ -24,7 +25,7 @@ const Dashboard: NextPage = () => {
     language: "node" | "go" | "python",
   ) => {
     try {
-      const response = await fetch(`${BACKEND_URL}/create/${language}`, {
+      const response = await authFetch(`${BACKEND_URL}/create/${language}`, {
         method: "POST",
         body: new URLSearchParams({
           name,
@@ -46,7 +47,7 @@ const Dashboard: NextPage = () => {
 
   const handleDeleteDeployment = async (name: string) => {
     try {
-      const response = await fetch(`${BACKEND_URL}/delete/${name}`, {
+      const response = await authFetch(`${BACKEND_URL}/delete/${name}`, {
         method: "DELETE",
       });
       if (!response.ok) {
@@ -59,7 +60,7 @@ const Dashboard: NextPage = () => {
   
   const handleBuildDeployment = async (name: string) => {
     try {
-      const response = await fetch(`${BACKEND_URL}/build/${name}`, {
+      const response = await authFetch(`${BACKEND_URL}/build/${name}`, {
         method: "POST",
       });
       if (!response.ok) {
@@ -75,8 +76,8 @@ const Dashboard: NextPage = () => {
       const deployment = deployments.find(d => d.name === name);
       if (!deployment) return;
 
-      const endpoint = deployment.status === "Running" ? "stop" : "start";
-      const response = await fetch(`${BACKEND_URL}/${endpoint}/${name}`, {
+      const endpoint = isUp(deployment.status) ? "stop" : "start";
+      const response = await authFetch(`${BACKEND_URL}/${endpoint}/${name}`, {
         method: "POST",
       });
       if (!response.ok) {
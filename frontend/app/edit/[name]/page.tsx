@@ -3,7 +3,7 @@
 import { useState, useEffect } from "react";
 import Header from "@/components/Header";
 import CodeEditor from "@/components/Editor";
-import { useAuth } from "@/utils/auth";
+import { authFetch, useAuth } from "@/utils/auth";
 import { NextPage } from "next";
 import { BACKEND_URL } from "@/lib/utils";
 import { showErrorAlert, showSuccessAlert } from "@/utils/alert";
@@ -41,7 +41,7 @@ const EditDeployment: NextPage = () => {
   useEffect(() => {
     if (isAuthenticated && name && typeof name === "string") {
       const fetchData = async () => {
-        const response = await fetch(`${BACKEND_URL}/deployments/${name}`);
+        const response = await authFetch(`${BACKEND_URL}/deployments/${name}`);
         const data = await response.json();
         if (response.ok) {
           setDeployment(data);
@@ -83,7 +83,7 @@ const EditDeployment: NextPage = () => {
     const packageBlob = new Blob([packageFile], { type: "text/plain" });
     formData.append("code", codeBlob, "main.go");
     formData.append("package", packageBlob, "go.mod");
-    const response = await fetch(`${BACKEND_URL}/upload/${name}`, {
+    const response = await authFetch(`${BACKEND_URL}/upload/${name}`, {
       method: "POST",
       body: formData,
     });
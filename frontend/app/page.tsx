@@ -18,20 +18,21 @@ const Login: NextPage = () => {
     }
   }, [router]);
 
-  const handleSubmit = (e: React.FormEvent): void => {
+  const handleSubmit = async (e: React.FormEvent): Promise<void> => {
     e.preventDefault();
     setIsLoading(true);
     setError('');
-    
-    // Simulating network latency
-    setTimeout(() => {
-      if (login(username, password)) {
+
+    try {
+      if (await login(username, password)) {
         router.push('/dashboard');
-      } else {
-        setError('Invalid credentials. Use admin/admin to login.');
-        setIsLoading(false);
+        return;
       }
-    }, 500);
+      setError('Invalid username or password.');
+    } catch {
+      setError('Unable to reach the server.');
+    }
+    setIsLoading(false);
   };
 
   return (
@@ -95,10 +96,7 @@ const Login: NextPage = () => {
                 {isLoading ? 'Signing in...' : 'Sign in'}
               </button>
             </div>
-            
-            <div className="text-center text-xs text-muted-foreground">
-              <p>Demo credentials: admin/admin</p>
-            </div>
+
           </form>
         </div>
       </div>
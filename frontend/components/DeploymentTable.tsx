@@ -1,6 +1,6 @@
 import React, { useState } from "react";
 import { useRouter } from "next/navigation";
-import { Deployment } from "../types";
+import { Deployment, isUp } from "../types";
 import { PuffLoader } from "react-spinners";
 import { motion } from "framer-motion";
 import { 
@@ -11,7 +11,8 @@ import {
   TrashIcon,
   CheckCircleIcon,
   XCircleIcon,
-  ClockIcon
+  ClockIcon,
+  ExclamationTriangleIcon
 } from "@heroicons/react/24/outline";
 
 interface DeploymentTableProps {
@@ -80,6 +81,28 @@ const DeploymentTable: React.FC<DeploymentTableProps> = ({
             Failed
           </motion.span>
         );
+      case "Unhealthy":
+        return (
+          <motion.span 
+            initial={{ opacity: 0, scale: 0.8 }}
+            animate={{ opacity: 1, scale: 1 }}
+            className="inline-flex items-center px-2.5 py-0.5 rounded-full text-xs font-medium bg-orange-100 text-orange-800 dark:bg-orange-900/30 dark:text-orange-400"
+          >
+            <ExclamationTriangleIcon className="w-3.5 h-3.5 mr-1" />
+            Unhealthy
+          </motion.span>
+        );
+      case "Crashed":
+        return (
+          <motion.span 
+            initial={{ opacity: 0, scale: 0.8 }}
+            animate={{ opacity: 1, scale: 1 }}
+            className="inline-flex items-center px-2.5 py-0.5 rounded-full text-xs font-medium bg-red-100 text-red-800 dark:bg-red-900/30 dark:text-red-400"
+          >
+            <XCircleIcon className="w-3.5 h-3.5 mr-1" />
+            Crashed
+          </motion.span>
+        );
       case "Building":
         return (
           <motion.span 
@@ -266,7 +289,7 @@ const DeploymentTable: React.FC<DeploymentTableProps> = ({
                         })}
                       </td>
                       <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-900 dark:text-gray-100">
-                        {deployment.status === "Running" && deployment.port && (
+                        {isUp(deployment.status) && deployment.port && (
                           <a
                             href={`http://localhost:${deployment.port}`}
                             target="_blank"
@@ -283,16 +306,16 @@ const DeploymentTable: React.FC<DeploymentTableProps> = ({
                             whileHover={{ scale: 1.05 }}
                             whileTap={{ scale: 0.95 }}
                             onClick={(e) => handleToggle(deployment.name, e)}
-                            disabled={!deployment.built && deployment.status !== "Running"}
+                            disabled={!deployment.built && !isUp(deployment.status)}
                             className={`inline-flex items-center px-3 py-1.5 border rounded-md text-sm font-medium ${
-                              deployment.status === "Running"
+                              isUp(deployment.status)
                                 ? "bg-red-100 text-red-800 border-red-200 hover:bg-red-200 dark:bg-red-900/30 dark:text-red-400 dark:border-red-800 dark:hover:bg-red-900/50"
                                 : deployment.built
                                 ? "bg-green-100 text-green-800 border-green-200 hover:bg-green-200 dark:bg-green-900/30 dark:text-green-400 dark:border-green-800 dark:hover:bg-green-900/50"
                                 : "bg-gray-100 text-gray-800 border-gray-200 cursor-not-allowed dark:bg-gray-800/30 dark:text-gray-400 dark:border-gray-700"
                             }`}
                           >
-                            {deployment.status === "Running" ? (
+                            {isUp(deployment.status) ? (
                               <>
                                 <StopIcon className="w-4 h-4 mr-1.5" />
                                 Stop
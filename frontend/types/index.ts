@@ -1,13 +1,16 @@
 export interface Deployment {
   id: string;
   name: string;
-  status: 'Running' | 'Stopped' | 'Failed' | 'Building' | 'Creating' | 'Starting';
+  status: 'Running' | 'Stopped' | 'Failed' | 'Building' | 'Creating' | 'Starting' | 'Unhealthy' | 'Crashed';
   createdAt: string;
   language: 'node' | 'go' | 'python';
   port?: string;
   built: boolean;
 }
 
+// isUp reports whether a deployment's function is running, healthy or not
+export const isUp = (status: Deployment['status']) => status === 'Running' || status === 'Unhealthy';
+
 export interface DeploymentFiles {
   packageFile: string;
   codeFile: string;
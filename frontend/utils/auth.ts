@@ -1,19 +1,25 @@
 "use client";
 import { useEffect } from 'react';
+import { BACKEND_URL } from '@/lib/utils';
 
-// Simple auth utility functions
-export function login(username: string, password: string): boolean {
-  // In a real app, you would make an API call here
-  if (username === 'admin' && password === 'admin') {
-    localStorage.setItem('isLoggedIn', 'true');
-    localStorage.setItem('username', username);
-    return true;
+// Log in against the backend and keep the session token in localStorage
+export async function login(username: string, password: string): Promise<boolean> {
+  const response = await fetch(`${BACKEND_URL}/auth/login`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username, password }),
+  });
+  if (!response.ok) {
+    return false;
   }
-  return false;
+  const data = await response.json();
+  localStorage.setItem('token', data.token);
+  localStorage.setItem('username', data.username);
+  return true;
 }
 
 export function logout(): void {
-  localStorage.removeItem('isLoggedIn');
+  localStorage.removeItem('token');
   localStorage.removeItem('username');
 }
 
@@ -21,8 +27,27 @@ export function getUsername(): string | null {
   return typeof window !== 'undefined' ? localStorage.getItem('username') : null;
 }
 
+export function getToken(): string | null {
+  return typeof window !== 'undefined' ? localStorage.getItem('token') : null;
+}
+
 export function isLoggedIn(): boolean {
-  return typeof window !== 'undefined' ? localStorage.getItem('isLoggedIn') === 'true' : false;
+  return getToken() !== null;
+}
+
+// fetch wrapper that sends the session token and logs out on 401
+export async function authFetch(input: string, init: RequestInit = {}): Promise<Response> {
+  const headers = new Headers(init.headers);
+  const token = getToken();
+  if (token) {
+    headers.set('Authorization', `Bearer ${token}`);
+  }
+  const response = await fetch(input, { ...init, headers });
+  if (response.status === 401) {
+    logout();
+    window.location.href = '/';
+  }
+  return response;
 }
 
 // Auth protection hook for pages
@@ -2,6 +2,8 @@
 import React, { createContext, useContext, useEffect, useState, ReactNode } from 'react';
 import { Deployment } from '../types';
 import { showErrorAlert, showInfoAlert, showSuccessAlert, showWarningAlert, showConfirmDialog } from './alert';
+import { authFetch, getToken } from './auth';
+import { BACKEND_URL } from '@/lib/utils';
 
 interface WebSocketContextType {
   deployments: Deployment[];
@@ -38,7 +40,7 @@ export const WebSocketProvider: React.FC<WebSocketProviderProps> = ({ children }
   useEffect(() => {
     const fetchDeployments = async () => {
       try {
-        const response = await fetch('http://localhost:8080/deployments');
+        const response = await authFetch(`${BACKEND_URL}/deployments/`);
         if (!response.ok) throw new Error('Failed to fetch deployments');
         const data = await response.json();
         setDeployments(data);
@@ -52,11 +54,11 @@ export const WebSocketProvider: React.FC<WebSocketProviderProps> = ({ children }
   }, []);
 
   useEffect(() => {
-    const socket = new WebSocket('ws://localhost:8080/ws');
+    const socket = new WebSocket(`${BACKEND_URL.replace(/^http/, 'ws')}/ws?token=${encodeURIComponent(getToken() || '')}`);
 
     const fetchDeployments = async () => {
       try {
-        const response = await fetch('http://localhost:8080/deployments');
+        const response = await authFetch(`${BACKEND_URL}/deployments/`);
         if (!response.ok) throw new Error('Failed to fetch deployments');
         const data = await response.json();
         setDeployments(data);